@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// Backend is a database engine under test. Each implementation owns its
+// connection and translates the project operations into native queries.
+type Backend interface {
+	Connect(ctx context.Context, m *metrics) error
+	// Ping reports whether the engine is ready to serve requests.
+	Ping() error
+	// Bootstrap creates the table, collection or index and the secondary
+	// indexes the operations rely on. It is idempotent; recreate drops
+	// the existing schema and data first.
+	Bootstrap(recreate bool) error
+	Create(p *project) error
+	Read(p *project) error
+	Update(p *project) error
+	Delete(p *project) error
+	Search(q searchQuery) error
+	SearchFTS(q searchQuery) error
+	// Scan reads up to q.Limit documents in price order starting at
+	// q.MinPrice, the equivalent of a YCSB short range scan.
+	Scan(q searchQuery) error
+	// Clean removes every benchmark document but keeps the schema.
+	Clean() error
+	// Load inserts the projects of items through the engine's fastest
+	// bulk path and completes every item.
+	Load(items []*batchItem)
+	// DropIndexes removes the secondary indexes before a bulk load and
+	// BuildIndexes creates them again.
+	DropIndexes() error
+	BuildIndexes() error
+	// DatasetSize returns the number of stored projects and the size in
+	// bytes of the data and its indexes.
+	DatasetSize() (records, bytes int64, err error)
+	Close() error
+	MetricsPort() int
+}
+
+// settingsReporter is implemented by backends whose numbers depend on
+// configuration that the op label does not show. The settings become
+// constant labels on every metric of the backend and are stored in the
+// report.
+type settingsReporter interface {
+	Settings() map[string]string
+}
+
+func backendSettings(b Backend) map[string]string {
+	if sr, ok := b.(settingsReporter); ok {
+		return sr.Settings()
+	}
+	return nil
+}
+
+type backendFactory func(c *Config) Backend
+
+var backends = map[string]backendFactory{}
+
+func registerBackend(name string, f backendFactory) {
+	if _, exists := backends[name]; exists {
+		panic(fmt.Sprintf("backend %q registered twice", name))
+	}
+	backends[name] = f
+}
+
+func newBackend(name string, c *Config) (Backend, error) {
+	f, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q", name)
+	}
+	return f(c), nil
+}
+
+func backendNames() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// waitHealthy pings b until it answers or timeout expires.
+func waitHealthy(b Backend, name string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := b.Ping()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s not healthy after %s: %w", name, timeout, err)
+		}
+		slog.Debug("Waiting for backend", "db", name, "error", err)
+		time.Sleep(time.Second)
+	}
+}
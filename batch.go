@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errBatchSkipped completes the items an ordered batch did not attempt
+// after an earlier item failed.
+var errBatchSkipped = errors.New("not executed after an earlier failure in the batch")
+
+// batcher coalesces writes from concurrent clients the way the
+// Elasticsearch bulk processor does: a batch is flushed once it holds Size
+// items or TimeoutMs after the last flush, by at most Flushers concurrent
+// requests. Clients block until their batch has been answered, so latency
+// includes the wait for the batch.
+type batcher struct {
+	ops     map[string]bool
+	ch      chan *batchItem
+	size    int
+	timeout time.Duration
+	sem     chan struct{}
+	flush   func(items []*batchItem)
+	m       *metrics
+	wg      sync.WaitGroup
+}
+
+type batchItem struct {
+	op   string
+	p    *project
+	done chan error
+}
+
+func (it *batchItem) complete(err error) {
+	it.done <- err
+}
+
+func failBatch(items []*batchItem, err error) {
+	for _, it := range items {
+		it.complete(err)
+	}
+}
+
+// newBatcher starts a batcher for ops. flush must complete every item it
+// is given.
+func newBatcher(c BatchConfig, m *metrics, ops []string, flush func(items []*batchItem)) *batcher {
+	b := &batcher{
+		ops:     make(map[string]bool),
+		ch:      make(chan *batchItem, positiveOr(c.Queue, 3000)),
+		size:    positiveOr(c.Size, 500),
+		timeout: time.Duration(positiveOr(c.TimeoutMs, 1)) * time.Millisecond,
+		sem:     make(chan struct{}, positiveOr(c.Flushers, 1)),
+		flush:   flush,
+		m:       m,
+	}
+	for _, op := range ops {
+		b.ops[op] = true
+	}
+	b.wg.Add(1)
+	go b.run()
+	return b
+}
+
+// handles reports whether op goes through the batcher. A nil batcher
+// handles nothing.
+func (b *batcher) handles(op string) bool {
+	return b != nil && b.ops[op]
+}
+
+func (b *batcher) submit(op string, p *project) error {
+	it := &batchItem{op: op, p: p, done: make(chan error, 1)}
+	b.ch <- it
+	b.observeQueue()
+	return <-it.done
+}
+
+func (b *batcher) run() {
+	defer b.wg.Done()
+	var batch []*batchItem
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		items := batch
+		batch = nil
+		b.sem <- struct{}{}
+		b.wg.Add(1)
+		go func() {
+			defer func() {
+				<-b.sem
+				b.wg.Done()
+			}()
+			start := time.Now()
+			b.flush(items)
+			b.observeFlush(len(items), start)
+		}()
+	}
+	timer := time.NewTimer(b.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case it, ok := <-b.ch:
+			b.observeQueue()
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, it)
+			if len(batch) >= b.size {
+				flush()
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(b.timeout)
+			}
+		case <-timer.C:
+			flush()
+			timer.Reset(b.timeout)
+		}
+	}
+}
+
+// close flushes what is queued and waits for every batch to be answered.
+func (b *batcher) close() {
+	if b == nil {
+		return
+	}
+	close(b.ch)
+	b.wg.Wait()
+}
+
+func (b *batcher) observeQueue() {
+	if b.m != nil {
+		b.m.bulkQueue.Set(float64(len(b.ch)))
+	}
+}
+
+func (b *batcher) observeFlush(items int, start time.Time) {
+	if b.m != nil {
+		b.m.bulkBatch.Set(float64(items))
+		b.m.bulkFlush.Set(time.Since(start).Seconds())
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"gopkg.in/yaml.v3"
@@ -8,12 +9,17 @@ import (
 
 type Config struct {
 	Debug bool `yaml:"debug"`
+	// Recreate drops and rebuilds every schema on startup; set by --recreate.
+	Recreate bool `yaml:"-"`
 
 	Elasticsearch ElasticsearchConfig `yaml:"elasticsearch"`
 	Postgres      PostgresConfig      `yaml:"postgres"`
 	Mongo         MongoConfig         `yaml:"mongo"`
 
-	Test TestConfig `yaml:"test"`
+	Test     TestConfig     `yaml:"test"`
+	Workload WorkloadConfig `yaml:"workload"`
+	Load     LoadConfig     `yaml:"load"`
+	Report   ReportConfig   `yaml:"report"`
 }
 
 type PostgresConfig struct {
@@ -23,6 +29,20 @@ type PostgresConfig struct {
 	Database       string `yaml:"database"`
 	MaxConnections int    `yaml:"maxConnections"`
 	MetricsPort    int    `yaml:"metricsPort"`
+	// RelationalMetricsPort serves the pgrel backend, which stores projects
+	// in typed columns instead of a JSONB document.
+	RelationalMetricsPort int `yaml:"relationalMetricsPort"`
+	// FTSStrategy is none (tsvector computed per query), gin (expression
+	// index), gin_stored (generated tsvector column), gist or trigram.
+	FTSStrategy string `yaml:"ftsStrategy"`
+	// Session holds server settings applied to every pooled connection,
+	// e.g. synchronous_commit, statement_timeout, work_mem or
+	// default_transaction_isolation.
+	Session map[string]string `yaml:"session"`
+	// Batch.Mode is off, pipeline (pgx batch of single-row statements for
+	// create, update and delete), multirow (one INSERT per batch of
+	// creates) or copy (COPY per batch of creates).
+	Batch BatchConfig `yaml:"batch"`
 }
 
 type MongoConfig struct {
@@ -32,23 +52,237 @@ type MongoConfig struct {
 	Database       string `yaml:"database"`
 	MaxConnections uint64 `yaml:"maxConnections"`
 	MetricsPort    int    `yaml:"metricsPort"`
+	// WriteConcern is the w value: a number of nodes or "majority".
+	WriteConcern string `yaml:"writeConcern"`
+	// Journal sets j to its value; when unset the server default applies.
+	Journal    *bool `yaml:"journal"`
+	WTimeoutMs int   `yaml:"wtimeoutMs"`
+	// ReadConcern is local, available, majority, linearizable or snapshot;
+	// empty leaves it to the server.
+	ReadConcern    string `yaml:"readConcern"`
+	ReadPreference string `yaml:"readPreference"`
+	// Batch.Mode is off, insert_many or bulk_write; both batch creates
+	// only.
+	Batch BatchConfig `yaml:"batch"`
+}
+
+// BatchConfig enables client-side batching of writes for pg and Mongo with
+// the knobs of the Elasticsearch bulk processor.
+type BatchConfig struct {
+	Mode      string `yaml:"mode"`
+	Size      int    `yaml:"size"`
+	TimeoutMs int    `yaml:"timeoutMs"`
+	Queue     int    `yaml:"queue"`
+	Flushers  int    `yaml:"flushers"`
+	// Ordered stops a Mongo batch at the first failed write.
+	Ordered bool `yaml:"ordered"`
 }
 
 type ElasticsearchConfig struct {
 	Host                    string `yaml:"host"`
 	MetricsPort             int    `yaml:"metricsPort"`
 	IndexName               string `yaml:"indexName"`
+	// ReadMode is "get" (realtime GET by id) or "search" (ids query).
+	ReadMode                string `yaml:"readMode"`
+	Shards                  int    `yaml:"shards"`
+	Replicas                int    `yaml:"replicas"`
+	// Refresh is the refresh policy per write (create, update, delete):
+	// false, wait_for, true or periodic. A bulk request uses the strictest
+	// policy among its items.
+	Refresh                 map[string]string `yaml:"refresh"`
+	// RefreshEveryMs is the period of the explicit index refreshes made
+	// for the periodic policy.
+	RefreshEveryMs          int    `yaml:"refreshEveryMs"`
+	// RefreshInterval is the index refresh_interval setting, e.g. 1s or -1.
+	RefreshInterval         string `yaml:"refreshInterval"`
+	// VisibilitySampleEvery measures for every Nth create how long the
+	// document takes to become searchable; 0 disables it.
+	VisibilitySampleEvery   int    `yaml:"visibilitySampleEvery"`
+	// WriteMode is bulk (writes are batched), direct (one Index, Update or
+	// Delete request per operation) or hybrid (only creates are batched).
+	WriteMode               string `yaml:"writeMode"`
+	// BulkQueue is the capacity of the bulk queue; writers block while it
+	// is full.
+	BulkQueue               int    `yaml:"bulkQueue"`
+	// A batch is sent once it holds BulkSize items or BulkBytes bytes, or
+	// BulkTimeoutMs after the last flush.
+	BulkSize                int    `yaml:"bulkSize"`
+	BulkBytes               int    `yaml:"bulkBytes"`
+	BulkTimeoutMs           int    `yaml:"bulkTimeoutMs"`
+	// BulkFlushers bounds the bulk requests in flight.
+	BulkFlushers            int    `yaml:"bulkFlushers"`
+	// BulkRetries is how often items rejected with 429 or 503 are resent,
+	// starting BulkBackoffMs apart and doubling each time.
+	BulkRetries             int    `yaml:"bulkRetries"`
+	BulkBackoffMs           int    `yaml:"bulkBackoffMs"`
+	// BulkRequestTimeoutS bounds a single _bulk request, including the
+	// ones sent by the preload.
+	BulkRequestTimeoutS     int    `yaml:"bulkRequestTimeoutS"`
 }
 
 type TestConfig struct {
 	MinClients     int `yaml:"minClients"`
 	MaxClients     int `yaml:"maxClients"`
 	StageIntervalS int `yaml:"stageIntervalS"`
+	// RequestDelayMs is no longer supported; applyLegacy rejects it.
 	RequestDelayMs int `yaml:"requestDelayMs"`
+	// Execution is "parallel" (all backends at once) or "sequential" (one
+	// backend after another, isolated from each other).
+	Execution      string `yaml:"execution"`
+	BackendPauseS  int    `yaml:"backendPauseS"`
+	HealthTimeoutS int    `yaml:"healthTimeoutS"`
+	// CooldownS is the former name of BackendPauseS.
+	CooldownS int `yaml:"cooldownS"`
+
+	// Warmup runs the first stage's clients before measurement starts and
+	// Cooldown keeps the last stage's clients running after it stops.
+	// Operations in either phase are labelled with their phase and left
+	// out of the report.
+	Warmup   PhaseConfig `yaml:"warmup"`
+	Cooldown PhaseConfig `yaml:"cooldown"`
+
+	Profile ProfileConfig `yaml:"profile"`
+
+	// DatasetSizes turns the run into a sweep over data volume: the
+	// dataset is grown to each size in turn and the warmup and load
+	// profile run again at every size. Loaded projects are kept in memory
+	// as targets for point operations, roughly 100 bytes each.
+	DatasetSizes []int `yaml:"datasetSizes"`
+}
+
+// ProfileConfig shapes the client count over time between MinClients and
+// MaxClients. Type is one of:
+//
+//	step    +Increment clients every StageIntervalS (the default)
+//	ramp    linear from min to max over DurationS
+//	spike   min clients, max clients for SpikeS, min again for RecoveryS
+//	soak    max clients for DurationS
+//	sine    min to max and back every PeriodS, for DurationS
+//	custom  the listed Stages with their own durations
+type ProfileConfig struct {
+	Type      string        `yaml:"type"`
+	Increment int           `yaml:"increment"`
+	DurationS int           `yaml:"durationS"`
+	SpikeS    int           `yaml:"spikeS"`
+	RecoveryS int           `yaml:"recoveryS"`
+	PeriodS   int           `yaml:"periodS"`
+	Stages    []StageConfig `yaml:"stages"`
+}
+
+// PhaseConfig ends a phase after DurationS or after Ops completed
+// operations, whichever comes first. A zero value disables the phase.
+type PhaseConfig struct {
+	DurationS int `yaml:"durationS"`
+	Ops       int `yaml:"ops"`
+}
+
+type StageConfig struct {
+	Clients   int `yaml:"clients"`
+	DurationS int `yaml:"durationS"`
+}
+
+// WorkloadConfig describes the operation mix every client runs. Scenario
+// files passed with --scenario usually only override this section.
+type WorkloadConfig struct {
+	Name        string            `yaml:"name"`
+	ThinkTimeMs int               `yaml:"thinkTimeMs"`
+	Operations  []OperationConfig `yaml:"operations"`
+
+	// Preset selects a YCSB core workload (a-f) that fills the fields
+	// above and below when they are left empty.
+	Preset string `yaml:"preset"`
+	// RecordCount records are inserted before the first stage and shared
+	// by all clients; RequestDistribution (uniform, zipfian or latest)
+	// picks which of them each operation targets.
+	RecordCount         int    `yaml:"recordCount"`
+	RequestDistribution string `yaml:"requestDistribution"`
+	// OperationCount ends the run after that many operations, or in a
+	// dataset-size sweep the stages of each size.
+	OperationCount int `yaml:"operationCount"`
+
+	// Mode is "closed" (each client waits for its previous operation and
+	// the think time) or "open" (operations are scheduled at Rate per
+	// second, plus RateStep for every following stage, and the clients of
+	// a stage only bound the concurrency). Arrival spaces open-loop
+	// operations "fixed" or "poisson".
+	Mode     string  `yaml:"mode"`
+	Rate     float64 `yaml:"rate"`
+	RateStep float64 `yaml:"rateStep"`
+	Arrival  string  `yaml:"arrival"`
+}
+
+// OperationConfig is one entry of the mix. Op is one of create, read,
+// update, delete, search, search_fts, scan or read_modify_write; parameters
+// that do not apply to the operation are ignored. For scan, Limit is the
+// maximum scan length.
+type OperationConfig struct {
+	Op       string  `yaml:"op"`
+	Weight   int     `yaml:"weight"`
+	Words    int     `yaml:"words"`
+	MaxPrice float64 `yaml:"maxPrice"`
+	Limit    int     `yaml:"limit"`
+	Keyword  string  `yaml:"keyword"`
+}
+
+type LoadConfig struct {
+	Records int `yaml:"records"`
+	Workers int `yaml:"workers"`
+	// BatchSize is the number of projects per bulk insert.
+	BatchSize int `yaml:"batchSize"`
+	// Preload loads Records projects before the stages of every run.
+	Preload bool `yaml:"preload"`
+	// DeferIndexes drops the secondary indexes for the load and builds
+	// them afterwards.
+	DeferIndexes bool `yaml:"deferIndexes"`
+	// Words is the textContent length of loaded projects. Zero means the
+	// size of a YCSB record for preset workloads and defaultTextWords
+	// otherwise.
+	Words int `yaml:"words"`
+}
+
+type ReportConfig struct {
+	Dir     string   `yaml:"dir"`
+	Formats []string `yaml:"formats"`
+	// LatexDir receives tables and pgfplots data; it is overwritten on
+	// every run so it can live inside the thesis sources.
+	LatexDir string `yaml:"latexDir"`
 }
 
 func (c *Config) loadConfig(path string) {
 	f, err := os.ReadFile(path)
 	yaml.Unmarshal(f, c)
 	fail(err, "yaml.Unmarshal failed")
-}
\ No newline at end of file
+}
+
+// applyLegacy moves renamed settings to their current names and rejects
+// settings that were replaced, so a config written for an older version
+// does not silently run a different setup.
+func (c *Config) applyLegacy() error {
+	if c.Test.CooldownS != 0 {
+		if c.Test.BackendPauseS != 0 {
+			return fmt.Errorf("test.cooldownS is the former name of test.backendPauseS; set only one of them")
+		}
+		c.Test.BackendPauseS = c.Test.CooldownS
+	}
+	if d := c.Test.RequestDelayMs; d != 0 {
+		return fmt.Errorf("test.requestDelayMs was replaced by workload.thinkTimeMs, the pause after every operation: "+
+			"%d ms after each iteration of the old five operations is thinkTimeMs: %d", d, d/5)
+	}
+	return nil
+}
+
+// applyScenario loads a scenario file on top of the config. A workload in the
+// scenario replaces the configured one instead of being merged into it, so a
+// preset is not mixed with the default operations.
+func (c *Config) applyScenario(path string) {
+	f, err := os.ReadFile(path)
+	fail(err, "Unable to read scenario")
+	var s struct {
+		Workload *WorkloadConfig `yaml:"workload"`
+	}
+	fail(yaml.Unmarshal(f, &s), "yaml.Unmarshal failed")
+	if s.Workload != nil {
+		c.Workload = WorkloadConfig{}
+	}
+	fail(yaml.Unmarshal(f, c), "yaml.Unmarshal failed")
+}
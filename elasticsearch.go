@@ -4,29 +4,54 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	es9 "github.com/elastic/go-elasticsearch/v9"
 )
 
+var errBulkTimeout = fmt.Errorf("bulk enqueue timeout: %w", context.DeadlineExceeded)
+
+// retryDirect reports whether a failed bulk operation should be repeated
+// through the single-document API. Item-level rejections are final.
+func retryDirect(err error) bool {
+	var se *statusError
+	return err != nil && !errors.As(err, &se)
+}
+
+var localIDSeq atomic.Int64
+
+// genLocalID names a document before it is enqueued, so a create that is
+// retried through the single-document API overwrites the same document.
 func genLocalID() string {
-	return fmt.Sprintf("local-%d", time.Now().UnixNano())
+	return fmt.Sprintf("local-%d-%d", time.Now().UnixNano(), localIDSeq.Add(1))
 }
 
 type elastic struct {
-	client  *es9.Client
-	context context.Context
-	Cfg     *ElasticsearchConfig
-	m       *metrics
+	client      *es9.Client
+	config      *Config
+	context     context.Context
+	Cfg         *ElasticsearchConfig
+	m           *metrics
 	bulkCh      chan *bulkItem
 	bulkSize    int
+	bulkBytes   int
 	bulkTimeout time.Duration
+	bulkRetries int
+	bulkBackoff time.Duration
+	bulkRequest time.Duration
 	bulkWG      sync.WaitGroup
-	pendingMu sync.Mutex
-	pending   map[string]chan struct{}
+	flushSem    chan struct{}
+	flushWG     sync.WaitGroup
+	pendingMu   sync.Mutex
+	pending     map[string]chan struct{}
+	refreshStop chan struct{}
+	created     atomic.Int64
 }
 
 type bulkItem struct {
@@ -42,91 +67,254 @@ type bulkResult struct {
 	err error
 }
 
-func NewElasticsearch(ctx context.Context, c *Config, m *metrics) (*elastic, error) {
-	addr := c.Elasticsearch.Host
+func init() {
+	registerBackend("es", func(c *Config) Backend { return &elastic{config: c, Cfg: &c.Elasticsearch} })
+}
+
+func (es *elastic) Connect(ctx context.Context, m *metrics) error {
+	addr := es.Cfg.Host
 	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
 		addr = fmt.Sprintf("http://%s", addr)
 	}
-	c.Elasticsearch.Host = addr
+	es.Cfg.Host = addr
 
 	cfg := es9.Config{Addresses: []string{addr}}
 	client, err := es9.NewClient(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create es client: %w", err)
+		return fmt.Errorf("unable to create es client: %w", err)
 	}
 
-	es := &elastic{
-		client:      client,
-		context:     ctx,
-		Cfg:         &c.Elasticsearch,
-		m:           m,
-			   bulkCh:      make(chan *bulkItem, 3000),
-			   bulkSize:    500,
-			   bulkTimeout: 1 * time.Millisecond,
-		pending:     make(map[string]chan struct{}),
+	if err := es.validateRefresh(); err != nil {
+		return err
+	}
+	switch es.writeMode() {
+	case "bulk", "direct", "hybrid":
+	default:
+		return fmt.Errorf("unknown es write mode %q", es.Cfg.WriteMode)
 	}
 
-	es.bulkWG.Add(1)
-	go func() {
-		defer es.bulkWG.Done()
-		es.runBulkProcessor()
-	}()
+	es.client = client
+	es.context = ctx
+	es.m = m
+	es.bulkSize = positiveOr(es.Cfg.BulkSize, 500)
+	es.bulkBytes = positiveOr(es.Cfg.BulkBytes, 5<<20)
+	es.bulkTimeout = time.Duration(positiveOr(es.Cfg.BulkTimeoutMs, 1)) * time.Millisecond
+	es.bulkRetries = max(es.Cfg.BulkRetries, 0)
+	es.bulkBackoff = time.Duration(positiveOr(es.Cfg.BulkBackoffMs, 100)) * time.Millisecond
+	es.bulkRequest = time.Duration(positiveOr(es.Cfg.BulkRequestTimeoutS, 15)) * time.Second
+	es.flushSem = make(chan struct{}, positiveOr(es.Cfg.BulkFlushers, 1))
+	es.pending = make(map[string]chan struct{})
+
+	if es.writeMode() != "direct" {
+		es.bulkCh = make(chan *bulkItem, positiveOr(es.Cfg.BulkQueue, 3000))
+		es.bulkWG.Add(1)
+		go func() {
+			defer es.bulkWG.Done()
+			es.runBulkProcessor()
+		}()
+	}
+	if es.periodicRefresh() {
+		es.refreshStop = make(chan struct{})
+		go es.runPeriodicRefresh(es.refreshStop)
+	}
+
+	var lastErr error
+	for i := 0; i < 10; i++ {
+		res, err := client.Info(client.Info.WithContext(ctx))
+		if res != nil && res.Body != nil {
+			res.Body.Close()
+		}
+		if err == nil && res != nil && res.StatusCode >= 200 && res.StatusCode < 300 {
+			return nil
+		}
+		lastErr = err
+		time.Sleep(2 * time.Second)
+	}
+	es.Close()
+	return fmt.Errorf("elasticsearch not reachable at %s: %w", addr, lastErr)
+}
 
-       var lastErr error
-       for i := 0; i < 10; i++ {
-	       res, err := client.Info(client.Info.WithContext(ctx))
-	       if res != nil && res.Body != nil {
-		       res.Body.Close()
-	       }
-	       if err == nil && res != nil && res.StatusCode >= 200 && res.StatusCode < 300 {
-		       return es, nil
-	       }
-	       lastErr = err
-	       time.Sleep(2 * time.Second)
-       }
-       close(es.bulkCh)
-       es.bulkWG.Wait()
-       return nil, lastErr
+func (es *elastic) Ping() error {
+	res, err := es.client.Cluster.Health(
+		es.client.Cluster.Health.WithContext(es.context),
+		es.client.Cluster.Health.WithWaitForStatus("yellow"),
+		es.client.Cluster.Health.WithTimeout(5*time.Second),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return responseError(res)
+}
+
+func (es *elastic) Bootstrap(recreate bool) error {
+	if recreate {
+		res, err := es.client.Indices.Delete([]string{es.Cfg.IndexName},
+			es.client.Indices.Delete.WithContext(es.context),
+			es.client.Indices.Delete.WithIgnoreUnavailable(true))
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if err := responseError(res); err != nil {
+			return err
+		}
+	}
+
+	res, err := es.client.Indices.Exists([]string{es.Cfg.IndexName}, es.client.Indices.Exists.WithContext(es.context))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode == 200 {
+		return es.putRefreshInterval()
+	}
+
+	shards, replicas := es.Cfg.Shards, es.Cfg.Replicas
+	if shards <= 0 {
+		shards = 1
+	}
+	settings := map[string]interface{}{
+		"number_of_shards":   shards,
+		"number_of_replicas": replicas,
+	}
+	if es.Cfg.RefreshInterval != "" {
+		settings["refresh_interval"] = es.Cfg.RefreshInterval
+	}
+	body := map[string]interface{}{
+		"settings": settings,
+		"mappings": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"price":       map[string]interface{}{"type": "float"},
+				"textContent": map[string]interface{}{"type": "text"},
+			},
+		},
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(body); err != nil {
+		return err
+	}
+	res, err = es.client.Indices.Create(es.Cfg.IndexName,
+		es.client.Indices.Create.WithContext(es.context),
+		es.client.Indices.Create.WithBody(&buf))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return responseError(res)
+}
+
+func (es *elastic) Close() error {
+	if es.refreshStop != nil {
+		close(es.refreshStop)
+		es.refreshStop = nil
+	}
+	if es.bulkCh == nil {
+		return nil
+	}
+	close(es.bulkCh)
+	es.bulkWG.Wait()
+	es.bulkCh = nil
+	return nil
+}
+
+func (es *elastic) MetricsPort() int {
+	return es.Cfg.MetricsPort
 }
 
 func (es *elastic) runBulkProcessor() {
+	ch := es.bulkCh
 	var batch []*bulkItem
+	var batchBytes int
+	flush := func() {
+		if len(batch) > 0 {
+			es.startFlush(batch)
+			batch, batchBytes = nil, 0
+		}
+	}
+	defer es.flushWG.Wait()
 	timer := time.NewTimer(es.bulkTimeout)
 	defer timer.Stop()
-       for {
-	       select {
-	       case it, ok := <-es.bulkCh:
-		       if !ok {
-			       if len(batch) > 0 {
-				       es.flushBulk(batch)
-			       }
-			       return
-		       }
-		       batch = append(batch, it)
-		       if len(batch) >= es.bulkSize {
-			       es.flushBulk(batch)
-			       batch = nil
-			       if !timer.Stop() {
-				       <-timer.C
-			       }
-			       timer.Reset(es.bulkTimeout)
-		       }
-	       case <-timer.C:
-		       if len(batch) > 0 {
-			       es.flushBulk(batch)
-			       batch = nil
-		       }
-		       timer.Reset(es.bulkTimeout)
-	       case <-es.context.Done():
-		       if len(batch) > 0 {
-			       es.flushBulk(batch)
-		       }
-		       return
-	       }
-       }
+	for {
+		select {
+		case it, ok := <-ch:
+			es.observeQueue(ch)
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, it)
+			batchBytes += len(it.body)
+			if len(batch) >= es.bulkSize || batchBytes >= es.bulkBytes {
+				flush()
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(es.bulkTimeout)
+			}
+		case <-timer.C:
+			flush()
+			timer.Reset(es.bulkTimeout)
+		case <-es.context.Done():
+			flush()
+			return
+		}
+	}
+}
+
+// startFlush sends batch on its own goroutine once one of the BulkFlushers
+// slots is free. While all slots are busy the processor stops draining the
+// queue, so writers block in EnqueueBulk instead of piling up requests.
+func (es *elastic) startFlush(batch []*bulkItem) {
+	es.flushSem <- struct{}{}
+	es.flushWG.Add(1)
+	go func() {
+		defer func() {
+			<-es.flushSem
+			es.flushWG.Done()
+		}()
+		es.flushBulk(batch)
+	}()
 }
 
+// flushBulk sends items and resends those rejected with 429 or 503 up to
+// BulkRetries times, doubling the pause between attempts.
 func (es *elastic) flushBulk(items []*bulkItem) {
+	backoff := es.bulkBackoff
+	for attempt := 0; len(items) > 0; attempt++ {
+		if attempt > 0 {
+			if es.m != nil {
+				es.m.bulkRetries.Add(float64(len(items)))
+			}
+			select {
+			case <-time.After(backoff):
+			case <-es.context.Done():
+				completeBulk(items, es.context.Err())
+				return
+			}
+			backoff *= 2
+		}
+		items = es.sendBulk(items, attempt < es.bulkRetries)
+	}
+}
+
+func completeBulk(items []*bulkItem, err error) {
+	for _, it := range items {
+		if it.done != nil {
+			it.done <- bulkResult{err: err}
+		}
+	}
+}
+
+// overloaded reports whether a status means Elasticsearch shed the request
+// and it is worth sending again.
+func overloaded(status int) bool {
+	return status == 429 || status == 503
+}
+
+// sendBulk sends items as one bulk request and completes them. With retry
+// set, items rejected as overloaded are returned instead of completed.
+func (es *elastic) sendBulk(items []*bulkItem, retry bool) []*bulkItem {
 	var buf bytes.Buffer
 	for _, it := range items {
 		switch it.op {
@@ -155,70 +343,95 @@ func (es *elastic) flushBulk(items []*bulkItem) {
 		}
 	}
 
-	ctx, cancel := context.WithTimeout(es.context, 15*time.Second)
+	ctx, cancel := context.WithTimeout(es.context, es.bulkRequest)
 	defer cancel()
+	refresh := "false"
+	for _, it := range items {
+		if r := es.refreshParam(bulkOps[it.op]); esRefreshRank[r] > esRefreshRank[refresh] {
+			refresh = r
+		}
+	}
 	flushStart := time.Now()
-       res, err := es.client.Bulk(bytes.NewReader(buf.Bytes()), es.client.Bulk.WithContext(ctx))
-       if err != nil {
-	       for _, it := range items {
-		       if it.done != nil {
-			       it.done <- bulkResult{err: err}
-		       }
-	       }
-	       return
-       }
-       defer res.Body.Close()
-       var resp map[string]interface{}
-       if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
-	       for _, it := range items {
-		       if it.done != nil {
-			       it.done <- bulkResult{err: err}
-		       }
-	       }
-	       return
-       }
-
-       itms, _ := resp["items"].([]interface{})
-       for i, it := range items {
-	       var resultErr error
-	       var gotID string
-	       if i < len(itms) {
-		       if entry, ok := itms[i].(map[string]interface{}); ok {
-			       for _, v := range entry {
-				       if m, ok := v.(map[string]interface{}); ok {
-					       if sid, ok := m["_id"].(string); ok {
-						       gotID = sid
-					       }
-					       if e, ok := m["error"]; ok {
-						       resultErr = fmt.Errorf("bulk item error: %v", e)
-					       }
-					       if statusF, ok := m["status"].(float64); ok {
-						       status := int(statusF)
-						       if status >= 400 && resultErr == nil {
-							       resultErr = fmt.Errorf("bulk item failed with status %d", status)
-						       }
-					       }
-				       }
-			       }
-		       }
-	       }
-	       if it.done != nil {
-		       it.done <- bulkResult{id: gotID, err: resultErr}
-	       }
-	       if resultErr == nil && es.m != nil {
-		       es.m.crudLatency.WithLabelValues(it.op).Observe(time.Since(flushStart).Seconds())
-	       }
-	       if it.op == "index" && resultErr == nil && gotID != "" {
-		       es.pendingMu.Lock()
-		       if ch, ok := es.pending[gotID]; ok {
-			       close(ch)
-			       delete(es.pending, gotID)
-		       }
-		       es.pendingMu.Unlock()
-	       }
-       }
+	defer es.observeFlush(len(items), flushStart)
+	res, err := es.client.Bulk(bytes.NewReader(buf.Bytes()), es.client.Bulk.WithContext(ctx), es.client.Bulk.WithRefresh(refresh))
+	if err != nil {
+		completeBulk(items, err)
+		return nil
+	}
+	defer res.Body.Close()
+	if err := responseError(res); err != nil {
+		var se *statusError
+		if retry && errors.As(err, &se) && overloaded(se.status) {
+			return items
+		}
+		completeBulk(items, err)
+		return nil
+	}
+	var resp map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		completeBulk(items, err)
+		return nil
+	}
+
+	var again []*bulkItem
+	itms, _ := resp["items"].([]interface{})
+	for i, it := range items {
+		var resultErr error
+		var gotID string
+		var status float64
+		if i < len(itms) {
+			if entry, ok := itms[i].(map[string]interface{}); ok {
+				for _, v := range entry {
+					if m, ok := v.(map[string]interface{}); ok {
+						if sid, ok := m["_id"].(string); ok {
+							gotID = sid
+						}
+						status, _ = m["status"].(float64)
+						if e, ok := m["error"]; ok {
+							resultErr = &statusError{status: int(status), reason: fmt.Sprintf("bulk item error: %v", e)}
+						} else if status >= 400 {
+							resultErr = &statusError{status: int(status), reason: "bulk item failed"}
+						}
+					}
+				}
+			}
+		}
+		if retry && resultErr != nil && overloaded(int(status)) {
+			again = append(again, it)
+			continue
+		}
+		if it.done != nil {
+			it.done <- bulkResult{id: gotID, err: resultErr}
+		}
+		if it.op == "index" && resultErr == nil && gotID != "" {
+			es.pendingMu.Lock()
+			if ch, ok := es.pending[gotID]; ok {
+				close(ch)
+				delete(es.pending, gotID)
+			}
+			es.pendingMu.Unlock()
+		}
+	}
+	return again
+}
+
+func (es *elastic) observeQueue(ch chan *bulkItem) {
+	if es.m != nil {
+		es.m.bulkQueue.Set(float64(len(ch)))
+	}
+}
+
+func (es *elastic) observeFlush(items int, start time.Time) {
+	if es.m != nil {
+		es.m.bulkBatch.Set(float64(items))
+		es.m.bulkFlush.Set(time.Since(start).Seconds())
+	}
 }
 
+// esBulkWait bounds how long a writer waits for queue space and for its
+// batch to be answered before falling back to the single-document API.
+const esBulkWait = 8 * time.Second
+
 func (es *elastic) EnqueueBulk(op, index, id string, body []byte) (string, error) {
 	if op == "index" && id == "" {
 		id = genLocalID()
@@ -233,21 +446,24 @@ func (es *elastic) EnqueueBulk(op, index, id string, body []byte) (string, error
 		es.pendingMu.Unlock()
 	}
 
+	deadline := time.NewTimer(esBulkWait)
+	defer deadline.Stop()
 	select {
-		case es.bulkCh <- it:
-		default:
-			es.flushBulk([]*bulkItem{it})
+	case es.bulkCh <- it:
+		es.observeQueue(es.bulkCh)
+	case <-deadline.C:
+		return id, errBulkTimeout
 	}
 
 	select {
-		case res := <-it.done:
-			if res.id != "" {
-				return res.id, res.err
-			}
-			return id, res.err
-		case <-time.After(8 * time.Second):
-			return "", fmt.Errorf("bulk enqueue timeout")
+	case res := <-it.done:
+		if res.id != "" {
+			return res.id, res.err
 		}
+		return id, res.err
+	case <-deadline.C:
+		return id, errBulkTimeout
+	}
 }
 
 func (es *elastic) WaitForIndex(id string, timeout time.Duration) bool {
@@ -263,4 +479,518 @@ func (es *elastic) WaitForIndex(id string, timeout time.Duration) bool {
 	case <-time.After(timeout):
 		return false
 	}
-}
\ No newline at end of file
+}
+
+func (es *elastic) Create(p *project) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if es.bulkWrite("create") {
+		id, err := es.EnqueueBulk("index", es.Cfg.IndexName, p.ElasticsearchId, b)
+		if !retryDirect(err) {
+			if err != nil {
+				return err
+			}
+			p.ElasticsearchId = id
+			es.sampleVisibility(p.ElasticsearchId)
+			return nil
+		}
+		// The item may still be indexed by the bulk request, so the
+		// fallback reuses its id instead of creating a second document.
+		p.ElasticsearchId = id
+	}
+	res, err := es.client.Index(es.Cfg.IndexName, bytes.NewReader(b), es.client.Index.WithDocumentID(p.ElasticsearchId), es.client.Index.WithContext(es.context), es.client.Index.WithRefresh(es.refreshParam("create")))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := responseError(res); err != nil {
+		return err
+	}
+	var r map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return err
+	}
+	if id2, ok := r["_id"].(string); ok {
+		p.ElasticsearchId = id2
+	}
+	es.sampleVisibility(p.ElasticsearchId)
+	return nil
+}
+
+func (es *elastic) Settings() map[string]string {
+	s := map[string]string{"read_mode": es.readMode(), "write_mode": es.writeMode()}
+	for _, op := range []string{"create", "update", "delete"} {
+		s["refresh_"+op] = es.refreshPolicy(op)
+	}
+	s["refresh_interval"] = es.Cfg.RefreshInterval
+	if s["refresh_interval"] == "" {
+		s["refresh_interval"] = "default"
+	}
+	return s
+}
+
+// esRefreshRank orders the refresh parameter values by strictness. The
+// periodic policy sends false and refreshes the index from the client.
+var esRefreshRank = map[string]int{"false": 0, "periodic": 0, "wait_for": 1, "true": 2}
+
+// bulkOps maps bulk actions to the workload operation they serve.
+var bulkOps = map[string]string{"index": "create", "update": "update", "delete": "delete"}
+
+func (es *elastic) validateRefresh() error {
+	for op, policy := range es.Cfg.Refresh {
+		if op != "create" && op != "update" && op != "delete" {
+			return fmt.Errorf("es refresh: unknown operation %q", op)
+		}
+		if _, ok := esRefreshRank[policy]; !ok {
+			return fmt.Errorf("es refresh: unknown policy %q for %s", policy, op)
+		}
+	}
+	return nil
+}
+
+func (es *elastic) refreshPolicy(op string) string {
+	if p := es.Cfg.Refresh[op]; p != "" {
+		return p
+	}
+	return "false"
+}
+
+// refreshParam is the refresh parameter sent with a write for op.
+func (es *elastic) refreshParam(op string) string {
+	if p := es.refreshPolicy(op); p != "periodic" {
+		return p
+	}
+	return "false"
+}
+
+func (es *elastic) periodicRefresh() bool {
+	for _, policy := range es.Cfg.Refresh {
+		if policy == "periodic" {
+			return true
+		}
+	}
+	return false
+}
+
+// runPeriodicRefresh refreshes the index every RefreshEveryMs until the
+// backend is closed.
+func (es *elastic) runPeriodicRefresh(stop <-chan struct{}) {
+	every := time.Duration(es.Cfg.RefreshEveryMs) * time.Millisecond
+	if every <= 0 {
+		every = time.Second
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			res, err := es.client.Indices.Refresh(
+				es.client.Indices.Refresh.WithContext(es.context),
+				es.client.Indices.Refresh.WithIndex(es.Cfg.IndexName),
+			)
+			if err == nil {
+				err = responseError(res)
+				res.Body.Close()
+			}
+			if err != nil {
+				slog.Debug("periodic refresh failed", "error", err)
+			}
+		case <-stop:
+			return
+		case <-es.context.Done():
+			return
+		}
+	}
+}
+
+// putRefreshInterval applies RefreshInterval to an index that already
+// exists, so changing it does not need --recreate.
+func (es *elastic) putRefreshInterval() error {
+	if es.Cfg.RefreshInterval == "" {
+		return nil
+	}
+	return es.setRefreshInterval(es.Cfg.RefreshInterval)
+}
+
+// setRefreshInterval changes refresh_interval of the index; nil restores
+// the Elasticsearch default.
+func (es *elastic) setRefreshInterval(v interface{}) error {
+	body := map[string]interface{}{"index": map[string]interface{}{"refresh_interval": v}}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(body); err != nil {
+		return err
+	}
+	res, err := es.client.Indices.PutSettings(&buf,
+		es.client.Indices.PutSettings.WithContext(es.context),
+		es.client.Indices.PutSettings.WithIndex(es.Cfg.IndexName))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return responseError(res)
+}
+
+// DropIndexes disables refreshes for the duration of a load. Documents are
+// indexed on ingest either way, so making them searchable afterwards is
+// the build step.
+func (es *elastic) DropIndexes() error {
+	return es.setRefreshInterval("-1")
+}
+
+// BuildIndexes restores the configured refresh interval and refreshes the
+// index so that every loaded document is searchable.
+func (es *elastic) BuildIndexes() error {
+	var interval interface{}
+	if es.Cfg.RefreshInterval != "" {
+		interval = es.Cfg.RefreshInterval
+	}
+	if err := es.setRefreshInterval(interval); err != nil {
+		return err
+	}
+	res, err := es.client.Indices.Refresh(
+		es.client.Indices.Refresh.WithContext(es.context),
+		es.client.Indices.Refresh.WithIndex(es.Cfg.IndexName),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return responseError(res)
+}
+
+// DatasetSize reports the primary document count and the store size of
+// all copies of the index.
+func (es *elastic) DatasetSize() (int64, int64, error) {
+	res, err := es.client.Indices.Stats(
+		es.client.Indices.Stats.WithContext(es.context),
+		es.client.Indices.Stats.WithIndex(es.Cfg.IndexName),
+		es.client.Indices.Stats.WithMetric("docs", "store"),
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer res.Body.Close()
+	if err := responseError(res); err != nil {
+		return 0, 0, err
+	}
+	var r struct {
+		All struct {
+			Primaries struct {
+				Docs struct {
+					Count int64 `json:"count"`
+				} `json:"docs"`
+			} `json:"primaries"`
+			Total struct {
+				Store struct {
+					SizeInBytes int64 `json:"size_in_bytes"`
+				} `json:"store"`
+			} `json:"total"`
+		} `json:"_all"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return 0, 0, err
+	}
+	return r.All.Primaries.Docs.Count, r.All.Total.Store.SizeInBytes, nil
+}
+
+// Load indexes the projects of items with one bulk request, letting
+// Elasticsearch assign the ids.
+func (es *elastic) Load(items []*batchItem) {
+	bulk := make([]*bulkItem, len(items))
+	for i, it := range items {
+		body, err := json.Marshal(it.p)
+		if err != nil {
+			failBatch(items, err)
+			return
+		}
+		bulk[i] = &bulkItem{op: "index", index: es.Cfg.IndexName, body: body, done: make(chan bulkResult, 1)}
+	}
+	// Each request stays within bulkBytes, like the batches of the bulk
+	// processor.
+	for start := 0; start < len(bulk); {
+		end, size := start, 0
+		for end < len(bulk) && (end == start || size+len(bulk[end].body) <= es.bulkBytes) {
+			size += len(bulk[end].body)
+			end++
+		}
+		es.flushBulk(bulk[start:end])
+		start = end
+	}
+	for i, it := range items {
+		res := <-bulk[i].done
+		if res.err == nil {
+			it.p.ElasticsearchId = res.id
+		}
+		it.complete(res.err)
+	}
+}
+
+const (
+	esVisibilityPoll    = 10 * time.Millisecond
+	esVisibilityTimeout = 30 * time.Second
+)
+
+// sampleVisibility starts measuring the search visibility of every
+// VisibilitySampleEvery-th created document.
+func (es *elastic) sampleVisibility(id string) {
+	n := es.Cfg.VisibilitySampleEvery
+	if n <= 0 || es.m == nil || id == "" || es.created.Add(1)%int64(n) != 0 {
+		return
+	}
+	go es.measureVisibility(id, time.Now())
+}
+
+// measureVisibility polls an ids query until the document acknowledged at
+// acked is returned by search and records the delay.
+func (es *elastic) measureVisibility(id string, acked time.Time) {
+	for {
+		err := es.readSearch(&project{ElasticsearchId: id})
+		if err == nil {
+			observeVisibility(es.m, time.Since(acked))
+			return
+		}
+		if !errors.Is(err, errNotFound) || time.Since(acked) > esVisibilityTimeout {
+			slog.Debug("search visibility not measured", "id", id, "error", err)
+			return
+		}
+		select {
+		case <-time.After(esVisibilityPoll):
+		case <-es.context.Done():
+			return
+		}
+	}
+}
+
+func (es *elastic) writeMode() string {
+	if es.Cfg.WriteMode == "" {
+		return "bulk"
+	}
+	return es.Cfg.WriteMode
+}
+
+// bulkWrite reports whether op goes through the bulk processor. Hybrid
+// batches creates only, so updates and deletes keep one round-trip each
+// like in pg and Mongo.
+func (es *elastic) bulkWrite(op string) bool {
+	switch es.writeMode() {
+	case "direct":
+		return false
+	case "hybrid":
+		return op == "create"
+	}
+	return true
+}
+
+func (es *elastic) readMode() string {
+	if es.Cfg.ReadMode == "" {
+		return "get"
+	}
+	return es.Cfg.ReadMode
+}
+
+func (es *elastic) Read(p *project) error {
+	if es.readMode() == "search" {
+		return es.readSearch(p)
+	}
+	res, err := es.client.Get(es.Cfg.IndexName, p.ElasticsearchId, es.client.Get.WithContext(es.context), es.client.Get.WithRealtime(true))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := responseError(res); err != nil {
+		return err
+	}
+	var r struct {
+		Source json.RawMessage `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return err
+	}
+	return json.Unmarshal(r.Source, p)
+}
+
+// readSearch fetches p through an ids query. Unlike the realtime GET it only
+// sees documents that have been refreshed into a searchable segment.
+func (es *elastic) readSearch(p *project) error {
+	var buf bytes.Buffer
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"ids": map[string]interface{}{"values": []string{p.ElasticsearchId}},
+		},
+		"size": 1,
+	}
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		return err
+	}
+	res, err := es.client.Search(
+		es.client.Search.WithContext(es.context),
+		es.client.Search.WithIndex(es.Cfg.IndexName),
+		es.client.Search.WithBody(&buf),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := responseError(res); err != nil {
+		return err
+	}
+	var r struct {
+		Hits struct {
+			Hits []struct {
+				Source json.RawMessage `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return err
+	}
+	if len(r.Hits.Hits) == 0 {
+		return errNotFound
+	}
+	return json.Unmarshal(r.Hits.Hits[0].Source, p)
+}
+
+func (es *elastic) Update(p *project) error {
+	doc := map[string]interface{}{"doc": map[string]interface{}{"price": p.Price}}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(doc); err != nil {
+		return err
+	}
+	if es.bulkWrite("update") {
+		if _, err := es.EnqueueBulk("update", es.Cfg.IndexName, p.ElasticsearchId, buf.Bytes()); !retryDirect(err) {
+			return err
+		}
+	}
+	res, err := es.client.Update(es.Cfg.IndexName, p.ElasticsearchId, &buf, es.client.Update.WithContext(es.context), es.client.Update.WithRefresh(es.refreshParam("update")))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return responseError(res)
+}
+
+func (es *elastic) Delete(p *project) error {
+	if es.bulkWrite("delete") {
+		if _, err := es.EnqueueBulk("delete", es.Cfg.IndexName, p.ElasticsearchId, nil); !retryDirect(err) {
+			return err
+		}
+	}
+	res, err := es.client.Delete(es.Cfg.IndexName, p.ElasticsearchId, es.client.Delete.WithContext(es.context), es.client.Delete.WithRefresh(es.refreshParam("delete")))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return responseError(res)
+}
+
+func (es *elastic) Search(q searchQuery) error {
+	var buf bytes.Buffer
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"range": map[string]interface{}{"price": map[string]interface{}{"lt": q.MaxPrice}},
+		},
+		"size": 0,
+		"aggs": map[string]interface{}{
+			"avg_price": map[string]interface{}{"avg": map[string]interface{}{"field": "price"}},
+		},
+	}
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		return err
+	}
+	res, err := es.client.Search(
+		es.client.Search.WithContext(es.context),
+		es.client.Search.WithIndex(es.Cfg.IndexName),
+		es.client.Search.WithBody(&buf),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := responseError(res); err != nil {
+		return err
+	}
+	var r map[string]interface{}
+	return json.NewDecoder(res.Body).Decode(&r)
+}
+
+func (es *elastic) SearchFTS(q searchQuery) error {
+	var buf bytes.Buffer
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"match": map[string]interface{}{
+				"textContent": q.Keyword,
+			},
+		},
+	}
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		return err
+	}
+
+	res, err := es.client.Count(
+		es.client.Count.WithContext(es.context),
+		es.client.Count.WithIndex(es.Cfg.IndexName),
+		es.client.Count.WithBody(&buf),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := responseError(res); err != nil {
+		return err
+	}
+
+	var r map[string]interface{}
+	return json.NewDecoder(res.Body).Decode(&r)
+}
+
+func (es *elastic) Scan(q searchQuery) error {
+	var buf bytes.Buffer
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"range": map[string]interface{}{"price": map[string]interface{}{"gte": q.MinPrice}},
+		},
+		"sort": []interface{}{map[string]interface{}{"price": "asc"}},
+		"size": q.Limit,
+	}
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		return err
+	}
+	res, err := es.client.Search(
+		es.client.Search.WithContext(es.context),
+		es.client.Search.WithIndex(es.Cfg.IndexName),
+		es.client.Search.WithBody(&buf),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := responseError(res); err != nil {
+		return err
+	}
+	var r map[string]interface{}
+	return json.NewDecoder(res.Body).Decode(&r)
+}
+
+func (es *elastic) Clean() error {
+	query := map[string]interface{}{"query": map[string]interface{}{"match_all": map[string]interface{}{}}}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		return err
+	}
+	res, err := es.client.DeleteByQuery(
+		[]string{es.Cfg.IndexName}, &buf,
+		es.client.DeleteByQuery.WithContext(es.context),
+		es.client.DeleteByQuery.WithConflicts("proceed"),
+		es.client.DeleteByQuery.WithRefresh(true),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return nil
+	}
+	return responseError(res)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/elastic/go-elasticsearch/v9/esapi"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var errNotFound = errors.New("document not found")
+
+// statusError is returned when Elasticsearch answers a request or a bulk
+// item with a non-2xx status.
+type statusError struct {
+	status int
+	reason string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.status, e.reason)
+}
+
+func responseError(res *esapi.Response) error {
+	if !res.IsError() {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	return &statusError{status: res.StatusCode, reason: string(body)}
+}
+
+// classifyError maps an operation error onto the small set of reasons
+// exported in the crud_errors_total counter.
+func classifyError(err error) string {
+	var se *statusError
+	if errors.As(err, &se) {
+		switch {
+		case se.status == 404:
+			return "not_found"
+		case se.status == 409:
+			return "conflict"
+		case se.status == 429:
+			return "http_429"
+		case se.status >= 500:
+			return "http_5xx"
+		default:
+			return "http_4xx"
+		}
+	}
+
+	switch {
+	case errors.Is(err, errNotFound), errors.Is(err, pgx.ErrNoRows), errors.Is(err, mongo.ErrNoDocuments):
+		return "not_found"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded), mongo.IsTimeout(err):
+		return "timeout"
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return "timeout"
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "40001", "40P01", "23505":
+			return "conflict"
+		case "57014":
+			return "timeout"
+		}
+		return "server"
+	}
+
+	var ce *pgconn.ConnectError
+	if errors.As(err, &ce) || mongo.IsNetworkError(err) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) {
+		return "connection"
+	}
+	var oe *net.OpError
+	if errors.As(err, &oe) {
+		return "connection"
+	}
+
+	if mongo.IsDuplicateKeyError(err) {
+		return "conflict"
+	}
+	// Batched writes fail with a BulkWriteException or, per item, with
+	// one of its BulkWriteErrors.
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) {
+		for _, e := range bwe.WriteErrors {
+			if mongoConflict(e.Code) {
+				return "conflict"
+			}
+		}
+		return "server"
+	}
+	var bwErr mongo.BulkWriteError
+	if errors.As(err, &bwErr) {
+		if mongoConflict(bwErr.Code) {
+			return "conflict"
+		}
+		return "server"
+	}
+	var we mongo.WriteException
+	if errors.As(err, &we) {
+		for _, e := range we.WriteErrors {
+			if mongoConflict(e.Code) {
+				return "conflict"
+			}
+		}
+		return "server"
+	}
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		if cmdErr.HasErrorLabel("TransientTransactionError") || cmdErr.Code == 112 {
+			return "conflict"
+		}
+		return "server"
+	}
+
+	return "other"
+}
+
+// mongoConflict reports whether a write error code is a duplicate key or a
+// write conflict.
+func mongoConflict(code int) bool {
+	switch code {
+	case 11000, 11001, 112:
+		return true
+	}
+	return false
+}
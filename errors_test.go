@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestClassifyMongoBatchErrors(t *testing.T) {
+	dup := mongo.BulkWriteError{WriteError: mongo.WriteError{Code: 11000, Message: "E11000 duplicate key error"}}
+	other := mongo.BulkWriteError{WriteError: mongo.WriteError{Code: 121, Message: "document failed validation"}}
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"duplicate key item", dup, "conflict"},
+		{"wrapped duplicate key item", fmt.Errorf("create: %w", dup), "conflict"},
+		{"write conflict item", mongo.BulkWriteError{WriteError: mongo.WriteError{Code: 112}}, "conflict"},
+		{"other item", other, "server"},
+		{"exception with duplicate key", mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{other, dup}}, "conflict"},
+		{"exception without duplicate key", mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{other}}, "server"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.err); got != tt.want {
+				t.Errorf("classifyError(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
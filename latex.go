@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// latexPercentiles are the latency columns plotted against client count.
+var latexPercentiles = []struct {
+	column string
+	label  string
+	value  func(o opResult) float64
+}{
+	{"p50", "p50", func(o opResult) float64 { return o.P50Ms }},
+	{"p90", "p90", func(o opResult) float64 { return o.P90Ms }},
+	{"p99", "p99", func(o opResult) float64 { return o.P99Ms }},
+	{"p999", "p99.9", func(o opResult) float64 { return o.P999Ms }},
+}
+
+// writeLatex exports r as files meant to be \input into the thesis:
+//
+//	table-<db>.tex   tabular with one row per stage and op
+//	<db>-<op>.dat    pgfplots table of latency percentiles per client count
+//	                 and dataset size
+//	plot-<op>.tex    tikzpicture comparing every backend for one op
+//
+// The output contains no timestamps and is fully sorted so reruns diff
+// cleanly. Plots read their data from \benchdatadir, which defaults to the
+// current directory.
+func writeLatex(dir string, r *runResult) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("unable to create latex directory: %w", err)
+	}
+
+	ops := map[string][]string{}
+	for _, b := range r.Backends {
+		if err := writeLatexFile(filepath.Join(dir, "table-"+b.Name+".tex"), func(w io.Writer) error {
+			return writeLatexTable(w, b)
+		}); err != nil {
+			return err
+		}
+		for _, op := range backendOps(b) {
+			ops[op] = append(ops[op], b.Name)
+			if err := writeLatexFile(filepath.Join(dir, b.Name+"-"+op+".dat"), func(w io.Writer) error {
+				return writePgfData(w, b, op)
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for op, dbs := range ops {
+		sort.Strings(dbs)
+		if err := writeLatexFile(filepath.Join(dir, "plot-"+op+".tex"), func(w io.Writer) error {
+			return writePgfPlot(w, op, dbs)
+		}); err != nil {
+			return err
+		}
+	}
+	slog.Info("LaTeX export written", "dir", dir)
+	return nil
+}
+
+func writeLatexFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+func backendOps(b backendResult) []string {
+	seen := map[string]bool{}
+	var ops []string
+	for _, s := range b.Stages {
+		for _, o := range s.Ops {
+			if !seen[o.Op] {
+				seen[o.Op] = true
+				ops = append(ops, o.Op)
+			}
+		}
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+func writeLatexTable(w io.Writer, b backendResult) error {
+	fmt.Fprintln(w, `\begin{tabular}{rrlrrrrrrrrr}`)
+	fmt.Fprintln(w, `\hline`)
+	fmt.Fprintln(w, `Stage & Clients & Op & Count & Errors & op/s & Mean & p50 & p90 & p99 & p99.9 & Max \\`)
+	fmt.Fprintln(w, `\hline`)
+	for _, s := range b.Stages {
+		for _, o := range s.Ops {
+			fmt.Fprintf(w, "%d & %d & %s & %d & %d & %s & %s & %s & %s & %s & %s & %s \\\\\n",
+				s.Stage, s.Clients, latexEscape(o.Op), o.Count, o.Errors, formatFloat(o.Throughput),
+				formatFloat(o.MeanMs), formatFloat(o.P50Ms), formatFloat(o.P90Ms),
+				formatFloat(o.P99Ms), formatFloat(o.P999Ms), formatFloat(o.MaxMs))
+		}
+	}
+	fmt.Fprintln(w, `\hline`)
+	_, err := fmt.Fprintln(w, `\end{tabular}`)
+	return err
+}
+
+func writePgfData(w io.Writer, b backendResult, op string) error {
+	cols := []string{"clients", "dataset", "throughput"}
+	for _, p := range latexPercentiles {
+		cols = append(cols, p.column)
+	}
+	fmt.Fprintln(w, strings.Join(cols, " "))
+	for _, s := range b.Stages {
+		for _, o := range s.Ops {
+			if o.Op != op {
+				continue
+			}
+			row := []string{fmt.Sprint(s.Clients), fmt.Sprint(s.DatasetSize), formatFloat(o.Throughput)}
+			for _, p := range latexPercentiles {
+				row = append(row, formatFloat(p.value(o)))
+			}
+			fmt.Fprintln(w, strings.Join(row, " "))
+		}
+	}
+	return nil
+}
+
+func writePgfPlot(w io.Writer, op string, dbs []string) error {
+	fmt.Fprintln(w, `\providecommand{\benchdatadir}{.}`)
+	fmt.Fprintln(w, `\begin{tikzpicture}`)
+	fmt.Fprintln(w, `\begin{axis}[`)
+	fmt.Fprintf(w, "  title={%s},\n", latexEscape(op))
+	fmt.Fprintln(w, `  xlabel={Clients},`)
+	fmt.Fprintln(w, `  ylabel={Latency [ms]},`)
+	fmt.Fprintln(w, `  legend pos=north west,`)
+	fmt.Fprintln(w, `  legend cell align=left,`)
+	fmt.Fprintln(w, `]`)
+	for _, db := range dbs {
+		for _, p := range latexPercentiles {
+			fmt.Fprintf(w, "\\addplot table[x=clients,y=%s] {\\benchdatadir/%s-%s.dat};\n", p.column, db, op)
+			fmt.Fprintf(w, "\\addlegendentry{%s %s}\n", latexEscape(db), p.label)
+		}
+	}
+	fmt.Fprintln(w, `\end{axis}`)
+	_, err := fmt.Fprintln(w, `\end{tikzpicture}`)
+	return err
+}
+
+var latexReplacer = strings.NewReplacer(
+	`\`, `\textbackslash{}`, `_`, `\_`, `%`, `\%`, `&`, `\&`, `#`, `\#`,
+	`$`, `\$`, `{`, `\{`, `}`, `\}`, `~`, `\textasciitilde{}`, `^`, `\textasciicircum{}`,
+)
+
+func latexEscape(s string) string {
+	return latexReplacer.Replace(s)
+}
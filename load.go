@@ -0,0 +1,122 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// loadData inserts n projects of c.Words words into b through its bulk
+// path, in batches of c.BatchSize projects from c.Workers concurrent
+// clients, and returns the number of failed inserts. keep, when set,
+// receives every project that was created.
+func loadData(b Backend, n int, c LoadConfig, keep func(project)) int64 {
+	workers := positiveOr(c.Workers, 1)
+	batch := positiveOr(c.BatchSize, 1000)
+	words := positiveOr(c.Words, defaultTextWords)
+	var next, failed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				end := next.Add(int64(batch))
+				start := end - int64(batch)
+				if start >= int64(n) {
+					return
+				}
+				items := make([]*batchItem, min(end, int64(n))-start)
+				for j := range items {
+					p := newProject(words)
+					items[j] = &batchItem{op: "create", p: &p, done: make(chan error, 1)}
+				}
+				b.Load(items)
+				for _, it := range items {
+					if err := <-it.done; err != nil {
+						failed.Add(1)
+					} else if keep != nil {
+						keep(*it.p)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return failed.Load()
+}
+
+// preload fills b with c.Records projects and measures the ingest. With
+// DeferIndexes the secondary indexes are dropped for the load, so the
+// index build time covers creating them from scratch. keep receives the
+// loaded projects like in loadData.
+func preload(c LoadConfig, b Backend, dbType string, keep func(project)) (*preloadResult, error) {
+	if c.DeferIndexes {
+		if err := b.DropIndexes(); err != nil {
+			return nil, err
+		}
+	}
+	start := time.Now()
+	failed := loadData(b, c.Records, c, keep)
+	ingest := time.Since(start)
+
+	buildStart := time.Now()
+	if err := b.BuildIndexes(); err != nil {
+		return nil, err
+	}
+	build := time.Since(buildStart)
+
+	records, bytes, err := b.DatasetSize()
+	if err != nil {
+		return nil, err
+	}
+	r := &preloadResult{
+		Records:        c.Records,
+		Errors:         failed,
+		IngestS:        ingest.Seconds(),
+		IndexBuildS:    build.Seconds(),
+		TotalS:         time.Since(start).Seconds(),
+		DatasetRecords: records,
+		DatasetBytes:   bytes,
+	}
+	if ingest > 0 {
+		r.Throughput = float64(int64(c.Records)-failed) / ingest.Seconds()
+	}
+	slog.Info("Load finished", "db", dbType, "records", c.Records, "errors", failed,
+		"ingest", ingest.Round(time.Millisecond), "records_per_s", r.Throughput,
+		"index_build", build.Round(time.Millisecond), "dataset_records", records, "dataset_bytes", bytes)
+	return r, nil
+}
+
+// growDataset loads projects into b until it holds size of them and returns
+// the resulting count. keep receives the loaded projects like in loadData.
+func growDataset(c LoadConfig, b Backend, dbType string, size int, keep func(project)) (int64, error) {
+	// BuildIndexes is idempotent; for Elasticsearch it refreshes the index
+	// so the counts include every acknowledged document.
+	if err := b.BuildIndexes(); err != nil {
+		return 0, err
+	}
+	records, _, err := b.DatasetSize()
+	if err != nil {
+		return 0, err
+	}
+	missing := int64(size) - records
+	if missing <= 0 {
+		slog.Info("Dataset already at size", "db", dbType, "size", size, "records", records)
+		return records, nil
+	}
+
+	start := time.Now()
+	failed := loadData(b, int(missing), c, keep)
+	if err := b.BuildIndexes(); err != nil {
+		return 0, err
+	}
+	records, bytes, err := b.DatasetSize()
+	if err != nil {
+		return 0, err
+	}
+	slog.Info("Dataset grown", "db", dbType, "size", size, "loaded", missing, "errors", failed,
+		"duration", time.Since(start).Round(time.Millisecond), "records", records, "bytes", bytes)
+	return records, nil
+}
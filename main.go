@@ -1,46 +1,247 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log/slog"
+	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+const usage = `Usage: client <command> [flags]
+
+Commands:
+  run      run the benchmark stages (default)
+  load     prefill the backends with generated projects
+  report   render a stored JSON result
+  compare  diff two stored JSON results
+  clean    drop all benchmark documents
+
+Run "client <command> -h" for the flags of a command.
+`
+
 func main() {
-	cfg := new(Config)
-	cfg.loadConfig("config.yaml")
+	cmd, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "run":
+		runCmd(args)
+	case "load":
+		loadCmd(args)
+	case "report":
+		reportCmd(args)
+	case "compare":
+		compareCmd(args)
+	case "clean":
+		cleanCmd(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+}
+
+// commonFlags are shared by every command that talks to the databases.
+type commonFlags struct {
+	config   string
+	scenario string
+	backends string
+	recreate bool
+}
+
+func (f *commonFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.config, "config", "config.yaml", "path to the configuration file")
+	fs.StringVar(&f.scenario, "scenario", "", "YAML file applied on top of the configuration")
+	fs.StringVar(&f.backends, "backends", "", "comma separated backends to use (default all: "+strings.Join(backendNames(), ",")+")")
+}
+
+// registerRecreate adds --recreate to commands that bootstrap the schema.
+func (f *commonFlags) registerRecreate(fs *flag.FlagSet) {
+	fs.BoolVar(&f.recreate, "recreate", false, "drop and recreate tables, collections and indexes before starting")
+}
 
+func (f *commonFlags) load() (*Config, []string) {
+	cfg := new(Config)
+	cfg.loadConfig(f.config)
+	if f.scenario != "" {
+		cfg.applyScenario(f.scenario)
+	}
+	fail(cfg.applyLegacy(), "Invalid configuration")
+	cfg.Recreate = f.recreate
+	if cfg.Load.Words <= 0 {
+		cfg.Load.Words = defaultTextWords
+		if cfg.Workload.Preset != "" {
+			cfg.Load.Words = ycsbTextWords
+		}
+	}
 	if cfg.Debug {
 		slog.SetLogLoggerLevel(slog.LevelDebug)
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(3)
+	names := backendNames()
+	if f.backends != "" {
+		names = strings.Split(f.backends, ",")
+		for _, name := range names {
+			if _, ok := backends[name]; !ok {
+				fail(fmt.Errorf("unknown backend %q", name), "Invalid --backends")
+			}
+		}
+	}
+	return cfg, names
+}
 
-	go func() {
-		defer wg.Done()
-		reg := prometheus.NewRegistry()
-		m := NewMetrics(reg, "pg")
-		StartPrometheusServer(cfg.Postgres.MetricsPort, reg)
-		runTest(cfg, "pg", m)
-	}()
+func runCmd(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	var cf commonFlags
+	cf.register(fs)
+	cf.registerRecreate(fs)
+	fs.Parse(args)
+	cfg, names := cf.load()
 
-	go func() {
-		defer wg.Done()
+	result := &runResult{Started: time.Now(), Workload: cfg.Workload.Name, Mode: cfg.Workload.Mode}
+	var resultMu sync.Mutex
+	run := func(name string) {
+		b, err := newBackend(name, cfg)
+		fail(err, "Unable to create backend")
 		reg := prometheus.NewRegistry()
-		m := NewMetrics(reg, "mg")
-		StartPrometheusServer(cfg.Mongo.MetricsPort, reg)
-		runTest(cfg, "mg", m)
-	}()
+		m := NewMetrics(prometheus.WrapRegistererWith(backendSettings(b), reg), name)
+		StartPrometheusServer(b.MetricsPort(), reg)
+		br := runTest(cfg, b, name, m)
+		resultMu.Lock()
+		result.Backends = append(result.Backends, br)
+		resultMu.Unlock()
+	}
 
-	go func() {
-		defer wg.Done()
-		reg := prometheus.NewRegistry()
-		m := NewMetrics(reg, "es")
-		StartPrometheusServer(cfg.Elasticsearch.MetricsPort, reg)
-		runTest(cfg, "es", m)
-	}()
+	switch cfg.Test.Execution {
+	case "", "parallel":
+		var wg sync.WaitGroup
+		for _, name := range names {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				run(name)
+			}()
+		}
+		wg.Wait()
+	case "sequential":
+		for i, name := range names {
+			if i > 0 && cfg.Test.BackendPauseS > 0 {
+				slog.Info("Pausing before next backend", "db", name, "seconds", cfg.Test.BackendPauseS)
+				time.Sleep(time.Duration(cfg.Test.BackendPauseS) * time.Second)
+			}
+			run(name)
+		}
+	default:
+		fail(fmt.Errorf("unknown execution mode %q", cfg.Test.Execution), "Invalid test.execution")
+	}
+
+	result.Finished = time.Now()
+	result.sortBackends()
+	fail(writeReport(cfg.Report, result), "Unable to write report")
+}
 
+func loadCmd(args []string) {
+	fs := flag.NewFlagSet("load", flag.ExitOnError)
+	var cf commonFlags
+	cf.register(fs)
+	cf.registerRecreate(fs)
+	records := fs.Int("records", 0, "number of projects to insert (default load.records from the config)")
+	fs.Parse(args)
+	cfg, names := cf.load()
+	if *records > 0 {
+		cfg.Load.Records = *records
+	}
+
+	forEachBackend(cfg, names, func(name string, b Backend) {
+		fail(b.Bootstrap(cfg.Recreate), "Unable to bootstrap %s", name)
+		_, err := preload(cfg.Load, b, name, nil)
+		fail(err, "Unable to load %s", name)
+	})
+}
+
+func cleanCmd(args []string) {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	var cf commonFlags
+	cf.register(fs)
+	fs.Parse(args)
+	cfg, names := cf.load()
+
+	forEachBackend(cfg, names, func(name string, b Backend) {
+		fail(b.Clean(), "Unable to clean %s", name)
+		slog.Info("Benchmark data removed", "db", name)
+	})
+}
+
+// forEachBackend connects to every named backend in parallel and calls fn
+// with the open connection.
+func forEachBackend(cfg *Config, names []string, fn func(name string, b Backend)) {
+	var wg sync.WaitGroup
+	for _, name := range names {
+		b, err := newBackend(name, cfg)
+		fail(err, "Unable to create backend")
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fail(b.Connect(context.Background(), nil), "Unable to connect to %s", name)
+			defer b.Close()
+			fn(name, b)
+		}()
+	}
 	wg.Wait()
-}
\ No newline at end of file
+}
+
+func reportCmd(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	format := fs.String("format", "md", "output format: json, csv, md or ycsb")
+	latexDir := fs.String("latex", "", "also export LaTeX tables and pgfplots data to this directory")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: client report [flags] <result.json>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	r, err := loadResult(fs.Arg(0))
+	fail(err, "Unable to load result")
+
+	write, ok := reportWriters[*format]
+	if !ok {
+		fail(fmt.Errorf("unknown report format %q", *format), "Invalid --format")
+	}
+	fail(write(os.Stdout, r), "Unable to render report")
+	if *latexDir != "" {
+		fail(writeLatex(*latexDir, r), "Unable to export LaTeX")
+	}
+}
+
+func compareCmd(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: client compare <base.json> <new.json>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	base, err := loadResult(fs.Arg(0))
+	fail(err, "Unable to load result")
+	next, err := loadResult(fs.Arg(1))
+	fail(err, "Unable to load result")
+	fail(writeComparison(os.Stdout, base, next), "Unable to render comparison")
+}
@@ -4,20 +4,75 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func observeLatency(m *metrics, op string, start time.Time) {
+// observeLatency records the outcome of a single operation. Failed
+// operations are counted by reason and kept out of the latency histogram.
+// Only operations of the measure phase reach the in-process report.
+func observeLatency(m *metrics, op string, start time.Time, err error) {
+	observe(m, op, start, err, false)
+}
+
+// observeNested records a step of a composite operation such as
+// read_modify_write. It is reported under its own op like any other, but
+// the composite operation alone counts towards stage totals and phase
+// operation counts.
+func observeNested(m *metrics, op string, start time.Time, err error) {
+	observe(m, op, start, err, true)
+}
+
+func observe(m *metrics, op string, start time.Time, err error, nested bool) {
+	if m == nil {
+		return
+	}
+	elapsed := time.Since(start)
+	phase := m.phase()
+	if !nested {
+		m.completed.Add(1)
+	}
+	if phase == phaseMeasure {
+		m.stats.record(op, elapsed, err, nested)
+	}
+	if err != nil {
+		reason := classifyError(err)
+		stage := m.stageLabel()
+		m.crudOps.WithLabelValues(op, "failure", stage, phase).Inc()
+		m.crudErrors.WithLabelValues(op, reason, stage, phase).Inc()
+		slog.Debug("operation failed", "op", op, "reason", reason, "error", err)
+		return
+	}
+	stage := m.stageLabel()
+	m.crudOps.WithLabelValues(op, "success", stage, phase).Inc()
+	m.crudLatency.WithLabelValues(op, stage, phase).Observe(elapsed.Seconds())
+}
+
+// observeVisibility records how long a write took to become searchable. In
+// the measure phase it also reaches the report as the search_visibility op,
+// which is not a client operation and stays out of stage totals.
+func observeVisibility(m *metrics, d time.Duration) {
 	if m == nil {
-		 return
+		return
+	}
+	phase := m.phase()
+	if phase == phaseMeasure {
+		m.stats.record("search_visibility", d, nil, true)
 	}
-	elapsed := time.Since(start).Seconds()
-	m.crudLatency.WithLabelValues(op).Observe(elapsed)
+	m.visibility.WithLabelValues(phase).Observe(d.Seconds())
 }
 
+const (
+	phaseLoad     = "load"
+	phaseWarmup   = "warmup"
+	phaseMeasure  = "measure"
+	phaseCooldown = "cooldown"
+)
+
 var buckets = []float64{
 	0.00001, 0.000015, 0.00002, 0.000025, 0.00003, 0.000035, 0.00004, 0.000045,
 	0.00005, 0.000055, 0.00006, 0.000065, 0.00007, 0.000075, 0.00008, 0.000085,
@@ -55,6 +110,40 @@ var buckets = []float64{
 type metrics struct {
 	clients         *prometheus.GaugeVec
 	crudLatency     *prometheus.HistogramVec
+	crudErrors      *prometheus.CounterVec
+	crudOps         *prometheus.CounterVec
+	visibility      *prometheus.HistogramVec
+	bulkQueue       prometheus.Gauge
+	bulkBatch       prometheus.Gauge
+	bulkFlush       prometheus.Gauge
+	bulkRetries     prometheus.Counter
+	stageGauge      prometheus.Gauge
+	stage           atomic.Int64
+	curPhase        atomic.Value
+	completed       atomic.Int64
+	stats           *stageStats
+}
+
+func (m *metrics) setPhase(phase string) {
+	m.curPhase.Store(phase)
+}
+
+func (m *metrics) phase() string {
+	if p, ok := m.curPhase.Load().(string); ok {
+		return p
+	}
+	return phaseMeasure
+}
+
+// setStage marks the start of stage id. Operations are attributed to the
+// stage in which they complete.
+func (m *metrics) setStage(id int) {
+	m.stage.Store(int64(id))
+	m.stageGauge.Set(float64(id))
+}
+
+func (m *metrics) stageLabel() string {
+	return strconv.FormatInt(m.stage.Load(), 10)
 }
 
 func NewMetrics(reg prometheus.Registerer, dbLabel string) *metrics {
@@ -70,9 +159,60 @@ func NewMetrics(reg prometheus.Registerer, dbLabel string) *metrics {
 		       Help:      "Latency of CRUD operations in seconds.",
 		       Buckets:   buckets,
 		       ConstLabels: prometheus.Labels{"db": dbLabel},
-	       }, []string{"op"}),
+	       }, []string{"op", "stage", "phase"}),
+	       crudErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+		       Namespace: "client",
+		       Name:      "crud_errors_total",
+		       Help:      "Number of failed CRUD operations by error reason.",
+		       ConstLabels: prometheus.Labels{"db": dbLabel},
+	       }, []string{"op", "reason", "stage", "phase"}),
+	       crudOps: prometheus.NewCounterVec(prometheus.CounterOpts{
+		       Namespace: "client",
+		       Name:      "crud_operations_total",
+		       Help:      "Number of completed CRUD operations by outcome.",
+		       ConstLabels: prometheus.Labels{"db": dbLabel},
+	       }, []string{"op", "outcome", "stage", "phase"}),
+	       visibility: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		       Namespace: "client",
+		       Name:      "search_visibility_seconds",
+		       Help:      "Time from an acknowledged create until the document is returned by search.",
+		       Buckets:   buckets,
+		       ConstLabels: prometheus.Labels{"db": dbLabel},
+	       }, []string{"phase"}),
+	       bulkQueue: prometheus.NewGauge(prometheus.GaugeOpts{
+		       Namespace: "client",
+		       Name:      "bulk_queue_depth",
+		       Help:      "Writes waiting in the bulk queue.",
+		       ConstLabels: prometheus.Labels{"db": dbLabel},
+	       }),
+	       bulkBatch: prometheus.NewGauge(prometheus.GaugeOpts{
+		       Namespace: "client",
+		       Name:      "bulk_batch_size",
+		       Help:      "Items in the last bulk request.",
+		       ConstLabels: prometheus.Labels{"db": dbLabel},
+	       }),
+	       bulkFlush: prometheus.NewGauge(prometheus.GaugeOpts{
+		       Namespace: "client",
+		       Name:      "bulk_flush_seconds",
+		       Help:      "Duration of the last bulk request in seconds.",
+		       ConstLabels: prometheus.Labels{"db": dbLabel},
+	       }),
+	       bulkRetries: prometheus.NewCounter(prometheus.CounterOpts{
+		       Namespace: "client",
+		       Name:      "bulk_retries_total",
+		       Help:      "Bulk items resent after a 429 or 503 rejection.",
+		       ConstLabels: prometheus.Labels{"db": dbLabel},
+	       }),
+	       stageGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+		       Namespace: "client",
+		       Name:      "stage",
+		       Help:      "Id of the running load stage.",
+		       ConstLabels: prometheus.Labels{"db": dbLabel},
+	       }),
+	       stats: newStageStats(),
        }
-       reg.MustRegister(m.clients, m.crudLatency)
+       reg.MustRegister(m.clients, m.crudLatency, m.crudErrors, m.crudOps, m.visibility,
+	       m.bulkQueue, m.bulkBatch, m.bulkFlush, m.bulkRetries, m.stageGauge)
        return m
 }
 
@@ -2,43 +2,397 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
+func init() {
+	registerBackend("mg", func(c *Config) Backend { return &mongodb{config: c} })
+}
+
 type mongodb struct {
-	db     *mongo.Database
-	config *Config
+	db      *mongo.Database
+	config  *Config
 	context context.Context
+	batch   *batcher
 }
 
-func NewMongo(c *Config) *mongodb {
-	mg := mongodb{
-		config:  c,
-		context: context.Background(),
+func (mg *mongodb) Connect(ctx context.Context, m *metrics) error {
+	mg.context = ctx
+	if err := mg.mgConnect(); err != nil {
+		return err
 	}
-	mg.mgConnect()
-	return &mg
+	return mg.startBatcher(m)
 }
 
-func (mg *mongodb) mgConnect() {
+func (mg *mongodb) mgConnect() error {
 	var uri string
 	if mg.config.Mongo.User != "" && mg.config.Mongo.Password != "" {
 		uri = fmt.Sprintf("mongodb://%s:%s@%s:27017", mg.config.Mongo.User, mg.config.Mongo.Password, mg.config.Mongo.Host)
 	} else {
 		uri = fmt.Sprintf("mongodb://%s:27017", mg.config.Mongo.Host)
 	}
-	wc := writeconcern.W1()
-	// wc := writeconcern.Majority()
-	// wc := writeconcern.Journaled()
-	opts := options.Client().SetMaxPoolSize(mg.config.Mongo.MaxConnections).SetWriteConcern(wc)
+	wc, err := mg.writeConcern()
+	if err != nil {
+		return err
+	}
+	_, mode, err := mg.readPref()
+	if err != nil {
+		return err
+	}
+	rp, err := readpref.New(mode)
+	if err != nil {
+		return err
+	}
+	opts := options.Client().SetMaxPoolSize(mg.config.Mongo.MaxConnections).SetWriteConcern(wc).SetReadPreference(rp)
+	if rc := mg.config.Mongo.ReadConcern; rc != "" {
+		opts.SetReadConcern(&readconcern.ReadConcern{Level: rc})
+	}
+
+	client, err := mongo.Connect(mg.context, opts.ApplyURI(uri))
+	if err != nil {
+		return fmt.Errorf("unable to create connection pool: %w", err)
+	}
+
+	mg.db = client.Database(mg.config.Mongo.Database)
+	return nil
+}
+
+// writeConcern builds the configured write concern; w defaults to 1.
+func (mg *mongodb) writeConcern() (*writeconcern.WriteConcern, error) {
+	c := mg.config.Mongo
+	wc := &writeconcern.WriteConcern{W: 1, WTimeout: time.Duration(c.WTimeoutMs) * time.Millisecond}
+	switch c.WriteConcern {
+	case "":
+	case "majority":
+		wc.W = "majority"
+	default:
+		n, err := strconv.Atoi(c.WriteConcern)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid mongo write concern %q", c.WriteConcern)
+		}
+		wc.W = n
+	}
+	if c.Journal != nil {
+		j := *c.Journal
+		wc.Journal = &j
+	}
+	return wc, nil
+}
+
+func (mg *mongodb) readPref() (string, readpref.Mode, error) {
+	name := mg.config.Mongo.ReadPreference
+	if name == "" {
+		name = "primary"
+	}
+	mode, err := readpref.ModeFromString(name)
+	if err != nil {
+		return name, mode, fmt.Errorf("invalid mongo read preference %q", name)
+	}
+	return name, mode, nil
+}
+
+func (mg *mongodb) Settings() map[string]string {
+	c := mg.config.Mongo
+	w := c.WriteConcern
+	if w == "" {
+		w = "1"
+	}
+	rp, _, _ := mg.readPref()
+	rc := c.ReadConcern
+	if rc == "" {
+		rc = "default"
+	}
+	journal := "default"
+	if c.Journal != nil {
+		journal = strconv.FormatBool(*c.Journal)
+	}
+	return map[string]string{
+		"write_concern":   w,
+		"journal":         journal,
+		"wtimeout_ms":     strconv.Itoa(c.WTimeoutMs),
+		"read_concern":    rc,
+		"read_preference": rp,
+		"batch_mode":      mg.batchMode(),
+		"batch_ordered":   strconv.FormatBool(c.Batch.Ordered),
+	}
+}
+
+func (mg *mongodb) Ping() error {
+	return mg.db.Client().Ping(mg.context, readpref.Primary())
+}
+
+func (mg *mongodb) Bootstrap(recreate bool) error {
+	coll := mg.db.Collection("project")
+	if recreate {
+		if err := coll.Drop(mg.context); err != nil {
+			return err
+		}
+	}
+	var cmdErr mongo.CommandError
+	// 48 is NamespaceExists.
+	if err := mg.db.CreateCollection(mg.context, "project"); err != nil && !(errors.As(err, &cmdErr) && cmdErr.Code == 48) {
+		return err
+	}
+	return mg.BuildIndexes()
+}
+
+var mgIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "textContent", Value: "text"}}, Options: options.Index().SetName("textContent_text")},
+	{Keys: bson.D{{Key: "price", Value: 1}}, Options: options.Index().SetName("price_1")},
+}
+
+func (mg *mongodb) BuildIndexes() error {
+	_, err := mg.db.Collection("project").Indexes().CreateMany(mg.context, mgIndexes)
+	return err
+}
+
+// DropIndexes removes every index but _id.
+func (mg *mongodb) DropIndexes() error {
+	_, err := mg.db.Collection("project").Indexes().DropAll(mg.context)
+	return err
+}
+
+// DatasetSize reports the document count and the storage plus index size
+// of the collection, summed over shards.
+func (mg *mongodb) DatasetSize() (int64, int64, error) {
+	pipeline := mongo.Pipeline{{{Key: "$collStats", Value: bson.M{"storageStats": bson.M{}}}}}
+	cursor, err := mg.db.Collection("project").Aggregate(mg.context, pipeline)
+	if err != nil {
+		return 0, 0, err
+	}
+	var out []struct {
+		StorageStats struct {
+			Count     int64 `bson:"count"`
+			TotalSize int64 `bson:"totalSize"`
+		} `bson:"storageStats"`
+	}
+	if err := cursor.All(mg.context, &out); err != nil {
+		return 0, 0, err
+	}
+	var records, bytes int64
+	for _, s := range out {
+		records += s.StorageStats.Count
+		bytes += s.StorageStats.TotalSize
+	}
+	return records, bytes, nil
+}
+
+// Load inserts the projects of items with InsertMany.
+func (mg *mongodb) Load(items []*batchItem) {
+	mg.flushInsertMany(items)
+}
+
+func (mg *mongodb) Close() error {
+	mg.batch.close()
+	mg.batch = nil
+	if mg.db == nil {
+		return nil
+	}
+	return mg.db.Client().Disconnect(context.Background())
+}
+
+func (mg *mongodb) MetricsPort() int {
+	return mg.config.Mongo.MetricsPort
+}
+
+func (mg *mongodb) Create(p *project) error {
+	if mg.batch.handles("create") {
+		return mg.batch.submit("create", p)
+	}
+	res, err := mg.db.Collection("project").InsertOne(mg.context, p)
+	if err == nil {
+		if oid, ok := res.InsertedID.(primitive.ObjectID); ok {
+			p.MongoId = oid.Hex()
+		}
+	}
+	return err
+}
+
+func (mg *mongodb) Read(p *project) error {
+	id, err := primitive.ObjectIDFromHex(p.MongoId)
+	if err != nil {
+		return err
+	}
+	return mg.db.Collection("project").FindOne(mg.context, bson.M{"_id": id}).Decode(p)
+}
+
+func (mg *mongodb) Update(p *project) error {
+	id, err := primitive.ObjectIDFromHex(p.MongoId)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": id}
+	update := bson.M{"$set": bson.M{"price": p.Price}}
+	res, err := mg.db.Collection("project").UpdateOne(mg.context, filter, update)
+	if err == nil && res.MatchedCount == 0 {
+		return errNotFound
+	}
+	return err
+}
+
+func (mg *mongodb) Delete(p *project) error {
+	id, err := primitive.ObjectIDFromHex(p.MongoId)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": id}
+	res, err := mg.db.Collection("project").DeleteOne(mg.context, filter)
+	if err == nil && res.DeletedCount == 0 {
+		return errNotFound
+	}
+	return err
+}
+
+func (mg *mongodb) Search(q searchQuery) error {
+	pipeline := []bson.M{
+		{"$match": bson.M{"price": bson.M{"$lt": q.MaxPrice}}},
+		{"$limit": q.Limit},
+		{"$group": bson.M{"_id": nil, "avg_price": bson.M{"$avg": "$price"}}},
+	}
+	cursor, err := mg.db.Collection("project").Aggregate(mg.context, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(mg.context)
+	var out struct {
+		AvgPrice float64 `bson:"avg_price"`
+	}
+	if cursor.Next(mg.context) {
+		if err := cursor.Decode(&out); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (mg *mongodb) SearchFTS(q searchQuery) error {
+	filter := bson.M{"$text": bson.M{"$search": q.Keyword}}
+	_, err := mg.db.Collection("project").CountDocuments(mg.context, filter)
+	return err
+}
+
+func (mg *mongodb) Scan(q searchQuery) error {
+	filter := bson.M{"price": bson.M{"$gte": q.MinPrice}}
+	opts := options.Find().SetSort(bson.D{{Key: "price", Value: 1}}).SetLimit(int64(q.Limit))
+	cursor, err := mg.db.Collection("project").Find(mg.context, filter, opts)
+	if err != nil {
+		return err
+	}
+	var out []project
+	return cursor.All(mg.context, &out)
+}
+
+func (mg *mongodb) Clean() error {
+	_, err := mg.db.Collection("project").DeleteMany(mg.context, bson.M{})
+	return err
+}
+
+// mgBatchOps lists the operations each batch mode coalesces. BulkWrite
+// only reports match and delete counts for the whole batch, so an update or
+// delete of a missing document could not fail with not_found; both stay on
+// the single-document path.
+var mgBatchOps = map[string][]string{
+	"off":         nil,
+	"insert_many": {"create"},
+	"bulk_write":  {"create"},
+}
+
+func (mg *mongodb) batchMode() string {
+	if mg.config.Mongo.Batch.Mode == "" {
+		return "off"
+	}
+	return mg.config.Mongo.Batch.Mode
+}
+
+func (mg *mongodb) startBatcher(m *metrics) error {
+	mode := mg.batchMode()
+	ops, ok := mgBatchOps[mode]
+	if !ok {
+		return fmt.Errorf("unknown mongo batch mode %q", mode)
+	}
+	flush := mg.flushBulkWrite
+	switch mode {
+	case "off":
+		return nil
+	case "insert_many":
+		flush = mg.flushInsertMany
+	}
+	mg.batch = newBatcher(mg.config.Mongo.Batch, m, ops, flush)
+	return nil
+}
+
+// batchDoc is the document inserted for p. Ids are assigned by the client
+// because BulkWrite does not report the ids it generated.
+func batchDoc(p *project) (project, primitive.ObjectID) {
+	oid := primitive.NewObjectID()
+	doc := *p
+	doc.Id = oid
+	return doc, oid
+}
+
+func (mg *mongodb) flushInsertMany(items []*batchItem) {
+	docs := make([]interface{}, len(items))
+	oids := make([]primitive.ObjectID, len(items))
+	for i, it := range items {
+		docs[i], oids[i] = batchDoc(it.p)
+	}
+	opts := options.InsertMany().SetOrdered(mg.config.Mongo.Batch.Ordered)
+	_, err := mg.db.Collection("project").InsertMany(mg.context, docs, opts)
+	mg.completeBatch(items, oids, err)
+}
 
-	client, err := mongo.Connect(context.Background(), opts.ApplyURI(uri))
-	fail(err, "Unable to create connection pool")
+func (mg *mongodb) flushBulkWrite(items []*batchItem) {
+	models := make([]mongo.WriteModel, len(items))
+	oids := make([]primitive.ObjectID, len(items))
+	for i, it := range items {
+		var doc project
+		doc, oids[i] = batchDoc(it.p)
+		models[i] = mongo.NewInsertOneModel().SetDocument(doc)
+	}
+	opts := options.BulkWrite().SetOrdered(mg.config.Mongo.Batch.Ordered)
+	_, err := mg.db.Collection("project").BulkWrite(mg.context, models, opts)
+	mg.completeBatch(items, oids, err)
+}
 
-	dbOpts := options.Database().SetWriteConcern(wc)
-	mg.db = client.Database(mg.config.Mongo.Database, dbOpts)
+// completeBatch completes the creates in items from the outcome of an
+// InsertMany or BulkWrite over them.
+func (mg *mongodb) completeBatch(items []*batchItem, oids []primitive.ObjectID, err error) {
+	var bwe mongo.BulkWriteException
+	if err != nil && !errors.As(err, &bwe) {
+		failBatch(items, err)
+		return
+	}
+	failed := make(map[int]error, len(bwe.WriteErrors))
+	first := len(items)
+	for _, we := range bwe.WriteErrors {
+		failed[we.Index] = we
+		first = min(first, we.Index)
+	}
+	for i, it := range items {
+		if e, ok := failed[i]; ok {
+			it.complete(e)
+			continue
+		}
+		if mg.config.Mongo.Batch.Ordered && i > first {
+			it.complete(errBatchSkipped)
+			continue
+		}
+		if bwe.WriteConcernError != nil {
+			it.complete(bwe)
+			continue
+		}
+		it.p.MongoId = oids[i].Hex()
+		it.complete(nil)
+	}
 }
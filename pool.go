@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// pool keeps clients running across stages. Growing it starts new workers,
+// shrinking it stops the most recently started ones after their current
+// operation, and the rest keep going without a gap at the stage boundary.
+type pool struct {
+	ctx     context.Context
+	spawn   func(ctx context.Context)
+	cancels []context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func newPool(ctx context.Context, spawn func(ctx context.Context)) *pool {
+	return &pool{ctx: ctx, spawn: spawn}
+}
+
+func (p *pool) size() int {
+	return len(p.cancels)
+}
+
+func (p *pool) resize(n int) {
+	for len(p.cancels) < n {
+		ctx, cancel := context.WithCancel(p.ctx)
+		p.cancels = append(p.cancels, cancel)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.spawn(ctx)
+		}()
+	}
+	for len(p.cancels) > n {
+		last := len(p.cancels) - 1
+		p.cancels[last]()
+		p.cancels = p.cancels[:last]
+	}
+}
+
+// stop cancels every worker and waits for all of them, including those
+// removed by earlier resizes, to return. The pool can be grown again
+// afterwards.
+func (p *pool) stop() {
+	p.resize(0)
+	p.wg.Wait()
+}
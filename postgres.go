@@ -2,31 +2,548 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+func init() {
+	registerBackend("pg", func(c *Config) Backend { return &postgres{config: c, layout: pgDocument} })
+	registerBackend("pgrel", func(c *Config) Backend { return &postgres{config: c, layout: pgRelational, relational: true} })
+}
+
 type postgres struct {
-	dbpool *pgxpool.Pool
-	config *Config
-	context context.Context
+	dbpool     *pgxpool.Pool
+	config     *Config
+	context    context.Context
+	layout     pgLayout
+	relational bool
+	batch      *batcher
 }
 
-func NewPostgres(c *Config) *postgres {
-	pg := postgres{
-		config:  c,
-		context: context.Background(),
+func (pg *postgres) Connect(ctx context.Context, m *metrics) error {
+	pg.context = ctx
+	if err := pg.pgConnect(); err != nil {
+		return err
 	}
-	pg.pgConnect()
-	return &pg
+	return pg.startBatcher(m)
 }
 
-func (pg *postgres) pgConnect() {
+func (pg *postgres) pgConnect() error {
 	url := fmt.Sprintf("postgres://%s:%s@%s:5432/%s?pool_max_conns=%d",
 		pg.config.Postgres.User, pg.config.Postgres.Password, pg.config.Postgres.Host, pg.config.Postgres.Database, pg.config.Postgres.MaxConnections)
-	dbpool, err := pgxpool.New(context.Background(), url)
-	fail(err, "Unable to create connection pool")
+	poolCfg, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		return fmt.Errorf("unable to parse connection string: %w", err)
+	}
+	poolCfg.AfterConnect = pg.applySession
+	dbpool, err := pgxpool.NewWithConfig(pg.context, poolCfg)
+	if err != nil {
+		return fmt.Errorf("unable to create connection pool: %w", err)
+	}
 
 	pg.dbpool = dbpool
+	return nil
+}
+
+// applySession sets the configured session settings on a new connection,
+// so durability and isolation are chosen by the client rather than by
+// however the server happens to be started.
+func (pg *postgres) applySession(ctx context.Context, conn *pgx.Conn) error {
+	names := make([]string, 0, len(pg.config.Postgres.Session))
+	for name := range pg.config.Postgres.Session {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := conn.Exec(ctx, `SELECT set_config($1, $2, false)`, name, pg.config.Postgres.Session[name]); err != nil {
+			return fmt.Errorf("session setting %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (pg *postgres) Ping() error {
+	return pg.dbpool.Ping(pg.context)
+}
+
+// pgLayout is how projects are stored: as a JSONB document (pg) or in typed
+// columns (pgrel). Statements below are templates in which {table}, {price}
+// and {text} are replaced by the layout's table and column expressions.
+type pgLayout struct {
+	table  string
+	price  string
+	text   string
+	schema []string
+	// columns are set from the values returned by row.
+	columns []string
+	row     func(p *project) ([]any, error)
+	// update is used verbatim, since '{price}' is a JSON path in the
+	// document layout.
+	update string
+}
+
+var (
+	pgDocument = pgLayout{
+		table:   "project",
+		price:   "(jdoc -> 'price')::numeric",
+		text:    "jdoc ->> 'textContent'",
+		schema:  []string{`CREATE TABLE IF NOT EXISTS project (id serial PRIMARY KEY, jdoc jsonb NOT NULL)`},
+		columns: []string{"jdoc"},
+		row: func(p *project) ([]any, error) {
+			b, err := json.Marshal(p)
+			return []any{b}, err
+		},
+		update: `UPDATE project SET jdoc = jsonb_set(jdoc, '{price}', $1) WHERE id = $2`,
+	}
+	pgRelational = pgLayout{
+		table:   "project_rel",
+		price:   "price",
+		text:    "text_content",
+		schema:  []string{`CREATE TABLE IF NOT EXISTS project_rel (id serial PRIMARY KEY, price numeric NOT NULL, text_content text NOT NULL)`},
+		columns: []string{"price", "text_content"},
+		row: func(p *project) ([]any, error) {
+			return []any{p.Price, p.TextContent}, nil
+		},
+		update: `UPDATE project_rel SET price = $1 WHERE id = $2`,
+	}
+)
+
+func (l pgLayout) sql(stmt string) string {
+	return strings.NewReplacer("{table}", l.table, "{price}", l.price, "{text}", l.text).Replace(stmt)
+}
+
+// insert is an INSERT of rows projects returning their ids in order.
+func (l pgLayout) insert(rows int) string {
+	values := make([]string, rows)
+	n := 0
+	for i := range values {
+		params := make([]string, len(l.columns))
+		for j := range params {
+			n++
+			params[j] = "$" + strconv.Itoa(n)
+		}
+		values[i] = "(" + strings.Join(params, ", ") + ")"
+	}
+	return "INSERT INTO " + l.table + "(" + strings.Join(l.columns, ", ") + ") VALUES " + strings.Join(values, ", ") + " RETURNING id"
+}
+
+var pgIndexes = []string{
+	`CREATE INDEX IF NOT EXISTS {table}_price_idx ON {table} (({price}))`,
+}
+
+// pgFTSStrategy is one way of answering searchFTS. Only the objects of the
+// selected strategy exist after bootstrap, so runs with different
+// strategies do not pay for each other's indexes.
+type pgFTSStrategy struct {
+	index  string
+	schema []string
+	query  string
+}
+
+const pgFTSExpr = `to_tsvector('simple', {text})`
+
+var pgFTSStrategies = map[string]pgFTSStrategy{
+	// The tsvector is recomputed for every row on every query.
+	"none": {
+		query: `SELECT COUNT(*) FROM {table} WHERE ` + pgFTSExpr + ` @@ to_tsquery('simple', $1)`,
+	},
+	"gin": {
+		index:  "{table}_fts_gin",
+		schema: []string{`CREATE INDEX IF NOT EXISTS {table}_fts_gin ON {table} USING gin (` + pgFTSExpr + `)`},
+		query:  `SELECT COUNT(*) FROM {table} WHERE ` + pgFTSExpr + ` @@ to_tsquery('simple', $1)`,
+	},
+	"gin_stored": {
+		index: "{table}_fts_stored_gin",
+		schema: []string{
+			`ALTER TABLE {table} ADD COLUMN IF NOT EXISTS fts tsvector GENERATED ALWAYS AS (` + pgFTSExpr + `) STORED`,
+			`CREATE INDEX IF NOT EXISTS {table}_fts_stored_gin ON {table} USING gin (fts)`,
+		},
+		query: `SELECT COUNT(*) FROM {table} WHERE fts @@ to_tsquery('simple', $1)`,
+	},
+	"gist": {
+		index:  "{table}_fts_gist",
+		schema: []string{`CREATE INDEX IF NOT EXISTS {table}_fts_gist ON {table} USING gist (` + pgFTSExpr + `)`},
+		query:  `SELECT COUNT(*) FROM {table} WHERE ` + pgFTSExpr + ` @@ to_tsquery('simple', $1)`,
+	},
+	// Substring match rather than word match; the keyword is a single word
+	// so the counts are comparable.
+	"trigram": {
+		index: "{table}_fts_trgm",
+		schema: []string{
+			`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+			`CREATE INDEX IF NOT EXISTS {table}_fts_trgm ON {table} USING gin (({text}) gin_trgm_ops)`,
+		},
+		query: `SELECT COUNT(*) FROM {table} WHERE {text} ILIKE '%' || $1 || '%'`,
+	},
+}
+
+func (pg *postgres) ftsStrategy() (string, pgFTSStrategy, error) {
+	name := pg.config.Postgres.FTSStrategy
+	if name == "" {
+		name = "none"
+	}
+	s, ok := pgFTSStrategies[name]
+	if !ok {
+		return name, s, fmt.Errorf("unknown pg fts strategy %q", name)
+	}
+	return name, s, nil
+}
+
+func (pg *postgres) Settings() map[string]string {
+	name, _, _ := pg.ftsStrategy()
+	s := map[string]string{"fts_strategy": name, "batch_mode": pg.batchMode()}
+	for k, v := range pg.config.Postgres.Session {
+		s[pgLabelName.Replace(k)] = v
+	}
+	return s
+}
+
+// pgLabelName turns a setting name such as pg_trgm.similarity_threshold
+// into a valid Prometheus label name.
+var pgLabelName = strings.NewReplacer(".", "_", "-", "_")
+
+func (pg *postgres) Bootstrap(recreate bool) error {
+	name, _, err := pg.ftsStrategy()
+	if err != nil {
+		return err
+	}
+
+	stmts := []string{}
+	if recreate {
+		stmts = append(stmts, `DROP TABLE IF EXISTS {table}`)
+	}
+	stmts = append(stmts, pg.layout.schema...)
+	for other, s := range pgFTSStrategies {
+		if other != name && s.index != "" {
+			stmts = append(stmts, `DROP INDEX IF EXISTS `+s.index)
+		}
+	}
+	if name != "gin_stored" {
+		stmts = append(stmts, `ALTER TABLE {table} DROP COLUMN IF EXISTS fts`)
+	}
+	if err := pg.exec(stmts); err != nil {
+		return err
+	}
+	return pg.BuildIndexes()
+}
+
+// DropIndexes removes the price index and every full-text search object,
+// including the generated tsvector column.
+func (pg *postgres) DropIndexes() error {
+	stmts := []string{`DROP INDEX IF EXISTS {table}_price_idx`}
+	for _, s := range pgFTSStrategies {
+		if s.index != "" {
+			stmts = append(stmts, `DROP INDEX IF EXISTS `+s.index)
+		}
+	}
+	stmts = append(stmts, `ALTER TABLE {table} DROP COLUMN IF EXISTS fts`)
+	return pg.exec(stmts)
+}
+
+func (pg *postgres) BuildIndexes() error {
+	_, fts, err := pg.ftsStrategy()
+	if err != nil {
+		return err
+	}
+	return pg.exec(append(append([]string{}, pgIndexes...), fts.schema...))
+}
+
+func (pg *postgres) DatasetSize() (int64, int64, error) {
+	var records, bytes int64
+	err := pg.dbpool.QueryRow(pg.context, pg.layout.sql(`SELECT count(*), pg_total_relation_size('{table}') FROM {table}`)).Scan(&records, &bytes)
+	return records, bytes, err
+}
+
+// Load inserts the projects of items with COPY.
+func (pg *postgres) Load(items []*batchItem) {
+	pg.flushCopy(items)
+}
+
+func (pg *postgres) exec(stmts []string) error {
+	for _, stmt := range stmts {
+		stmt = pg.layout.sql(stmt)
+		if _, err := pg.dbpool.Exec(pg.context, stmt); err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	return nil
+}
+
+func (pg *postgres) Close() error {
+	pg.batch.close()
+	pg.batch = nil
+	if pg.dbpool != nil {
+		pg.dbpool.Close()
+	}
+	return nil
+}
+
+func (pg *postgres) MetricsPort() int {
+	if pg.relational {
+		return pg.config.Postgres.RelationalMetricsPort
+	}
+	return pg.config.Postgres.MetricsPort
+}
+
+func (pg *postgres) Create(p *project) error {
+	if pg.batch.handles("create") {
+		return pg.batch.submit("create", p)
+	}
+	row, err := pg.layout.row(p)
+	if err != nil {
+		return err
+	}
+	return pg.dbpool.QueryRow(pg.context, pg.layout.insert(1), row...).Scan(&p.PostgresId)
+}
+
+func (pg *postgres) Read(p *project) error {
+	if pg.relational {
+		var price float64
+		if err := pg.dbpool.QueryRow(pg.context, `SELECT price, text_content FROM project_rel WHERE id = $1`, p.PostgresId).Scan(&price, &p.TextContent); err != nil {
+			return err
+		}
+		p.Price = float32(price)
+		return nil
+	}
+	var b []byte
+	if err := pg.dbpool.QueryRow(pg.context, `SELECT jdoc FROM project WHERE id = $1`, p.PostgresId).Scan(&b); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, p)
+}
+
+func (pg *postgres) Update(p *project) error {
+	if pg.batch.handles("update") {
+		return pg.batch.submit("update", p)
+	}
+	tag, err := pg.dbpool.Exec(pg.context, pg.layout.update, p.Price, p.PostgresId)
+	if err == nil && tag.RowsAffected() == 0 {
+		return errNotFound
+	}
+	return err
+}
+
+func (pg *postgres) Delete(p *project) error {
+	if pg.batch.handles("delete") {
+		return pg.batch.submit("delete", p)
+	}
+	tag, err := pg.dbpool.Exec(pg.context, pg.layout.sql(pgDelete), p.PostgresId)
+	if err == nil && tag.RowsAffected() == 0 {
+		return errNotFound
+	}
+	return err
+}
+
+func (pg *postgres) Search(q searchQuery) error {
+	var avg sql.NullFloat64
+	err := pg.dbpool.QueryRow(pg.context, pg.layout.sql(`SELECT AVG(price) FROM (SELECT {price} as price FROM {table} WHERE {price} < $1 LIMIT $2) as limited_projects`), q.MaxPrice, q.Limit).Scan(&avg)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	return nil
+}
+
+func (pg *postgres) SearchFTS(q searchQuery) error {
+	_, fts, err := pg.ftsStrategy()
+	if err != nil {
+		return err
+	}
+	var count sql.NullInt64
+	err = pg.dbpool.QueryRow(pg.context, pg.layout.sql(fts.query), q.Keyword).Scan(&count)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	return nil
+}
+
+func (pg *postgres) Scan(q searchQuery) error {
+	if pg.relational {
+		rows, err := pg.dbpool.Query(pg.context,
+			`SELECT price, text_content FROM project_rel WHERE price >= $1 ORDER BY price LIMIT $2`,
+			q.MinPrice, q.Limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var price float64
+			var text string
+			if err := rows.Scan(&price, &text); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	}
+	rows, err := pg.dbpool.Query(pg.context,
+		`SELECT jdoc FROM project WHERE (jdoc -> 'price')::numeric >= $1 ORDER BY (jdoc -> 'price')::numeric LIMIT $2`,
+		q.MinPrice, q.Limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b []byte
+		if err := rows.Scan(&b); err != nil {
+			return err
+		}
+		var p project
+		if err := json.Unmarshal(b, &p); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (pg *postgres) Clean() error {
+	_, err := pg.dbpool.Exec(pg.context, pg.layout.sql(`TRUNCATE {table} RESTART IDENTITY`))
+	return err
+}
+
+const pgDelete = `DELETE FROM {table} WHERE id = $1`
+
+// pgBatchOps lists the operations each batch mode coalesces.
+var pgBatchOps = map[string][]string{
+	"off":      nil,
+	"pipeline": {"create", "update", "delete"},
+	"multirow": {"create"},
+	"copy":     {"create"},
+}
+
+func (pg *postgres) batchMode() string {
+	if pg.config.Postgres.Batch.Mode == "" {
+		return "off"
+	}
+	return pg.config.Postgres.Batch.Mode
+}
+
+func (pg *postgres) startBatcher(m *metrics) error {
+	mode := pg.batchMode()
+	ops, ok := pgBatchOps[mode]
+	if !ok {
+		return fmt.Errorf("unknown pg batch mode %q", mode)
+	}
+	flush := pg.flushPipeline
+	switch mode {
+	case "off":
+		return nil
+	case "multirow":
+		flush = pg.flushMultiRow
+	case "copy":
+		flush = pg.flushCopy
+	}
+	pg.batch = newBatcher(pg.config.Postgres.Batch, m, ops, flush)
+	return nil
+}
+
+// flushPipeline sends the statements of items in one round-trip. pgx runs
+// a batch as a single implicit transaction, so one failure fails the rest.
+func (pg *postgres) flushPipeline(items []*batchItem) {
+	batch := &pgx.Batch{}
+	queued := items[:0:0]
+	for _, it := range items {
+		switch it.op {
+		case "create":
+			row, err := pg.layout.row(it.p)
+			if err != nil {
+				it.complete(err)
+				continue
+			}
+			batch.Queue(pg.layout.insert(1), row...)
+		case "update":
+			batch.Queue(pg.layout.update, it.p.Price, it.p.PostgresId)
+		case "delete":
+			batch.Queue(pg.layout.sql(pgDelete), it.p.PostgresId)
+		}
+		queued = append(queued, it)
+	}
+	if len(queued) == 0 {
+		return
+	}
+
+	br := pg.dbpool.SendBatch(pg.context, batch)
+	defer br.Close()
+	for _, it := range queued {
+		if it.op == "create" {
+			it.complete(br.QueryRow().Scan(&it.p.PostgresId))
+			continue
+		}
+		tag, err := br.Exec()
+		if err == nil && tag.RowsAffected() == 0 {
+			err = errNotFound
+		}
+		it.complete(err)
+	}
+}
+
+// flushMultiRow inserts the projects of items with a single INSERT.
+func (pg *postgres) flushMultiRow(items []*batchItem) {
+	var args []any
+	for _, it := range items {
+		row, err := pg.layout.row(it.p)
+		if err != nil {
+			failBatch(items, err)
+			return
+		}
+		args = append(args, row...)
+	}
+	rows, err := pg.dbpool.Query(pg.context, pg.layout.insert(len(items)), args...)
+	if err != nil {
+		failBatch(items, err)
+		return
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err == nil && len(ids) != len(items) {
+		err = fmt.Errorf("insert returned %d ids for %d rows", len(ids), len(items))
+	}
+	if err != nil {
+		failBatch(items, err)
+		return
+	}
+	for i, it := range items {
+		it.p.PostgresId = ids[i]
+		it.complete(nil)
+	}
+}
+
+// flushCopy inserts the projects of items with COPY. COPY cannot return
+// the generated ids, so they are drawn from the id sequence first.
+func (pg *postgres) flushCopy(items []*batchItem) {
+	rows, err := pg.dbpool.Query(pg.context,
+		`SELECT nextval(pg_get_serial_sequence($1, 'id')) FROM generate_series(1, $2)`,
+		pg.layout.table, len(items))
+	if err != nil {
+		failBatch(items, err)
+		return
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		failBatch(items, err)
+		return
+	}
+
+	data := make([][]any, len(items))
+	for i, it := range items {
+		row, err := pg.layout.row(it.p)
+		if err != nil {
+			failBatch(items, err)
+			return
+		}
+		data[i] = append([]any{ids[i]}, row...)
+	}
+	columns := append([]string{"id"}, pg.layout.columns...)
+	if _, err := pg.dbpool.CopyFrom(pg.context, pgx.Identifier{pg.layout.table}, columns, pgx.CopyFromRows(data)); err != nil {
+		failBatch(items, err)
+		return
+	}
+	for i, it := range items {
+		it.p.PostgresId = ids[i]
+		it.complete(nil)
+	}
 }
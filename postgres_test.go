@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestPgLayoutInsert(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout pgLayout
+		rows   int
+		want   string
+	}{
+		{"document, one row", pgDocument, 1, "INSERT INTO project(jdoc) VALUES ($1) RETURNING id"},
+		{"document, three rows", pgDocument, 3, "INSERT INTO project(jdoc) VALUES ($1), ($2), ($3) RETURNING id"},
+		{"relational, one row", pgRelational, 1, "INSERT INTO project_rel(price, text_content) VALUES ($1, $2) RETURNING id"},
+		{"relational, two rows", pgRelational, 2, "INSERT INTO project_rel(price, text_content) VALUES ($1, $2), ($3, $4) RETURNING id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.layout.insert(tt.rows); got != tt.want {
+				t.Errorf("insert(%d) = %q, want %q", tt.rows, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// stagePlan is one stage of a load profile: how many clients run and for
+// how long.
+type stagePlan struct {
+	Clients  int
+	Duration time.Duration
+}
+
+// stages expands the configured load profile into the list of stages
+// runTest executes. Long phases (soak, spike and recovery) are cut into
+// StageIntervalS pieces so drift within them shows up in the report.
+func (c TestConfig) stages() ([]stagePlan, error) {
+	p := c.Profile
+	interval := time.Duration(c.StageIntervalS) * time.Second
+	if interval <= 0 && p.Type != "custom" {
+		return nil, fmt.Errorf("profile %q needs a positive stageIntervalS", p.Type)
+	}
+	duration := time.Duration(p.DurationS) * time.Second
+
+	var plan []stagePlan
+	switch p.Type {
+	case "", "step":
+		inc := p.Increment
+		if inc <= 0 {
+			inc = 1
+		}
+		for n := c.MinClients; n <= c.MaxClients; n += inc {
+			plan = append(plan, stagePlan{n, interval})
+		}
+	case "ramp":
+		steps := int(duration / interval)
+		if steps < 2 {
+			return nil, fmt.Errorf("ramp needs durationS of at least two stage intervals")
+		}
+		for i := 0; i < steps; i++ {
+			n := c.MinClients + int(math.Round(float64(c.MaxClients-c.MinClients)*float64(i)/float64(steps-1)))
+			plan = append(plan, stagePlan{n, interval})
+		}
+	case "spike":
+		plan = appendHold(plan, c.MinClients, interval, interval)
+		plan = appendHold(plan, c.MaxClients, time.Duration(p.SpikeS)*time.Second, interval)
+		plan = appendHold(plan, c.MinClients, time.Duration(p.RecoveryS)*time.Second, interval)
+	case "soak":
+		plan = appendHold(plan, c.MaxClients, duration, interval)
+	case "sine":
+		period := time.Duration(p.PeriodS) * time.Second
+		if period <= 0 {
+			return nil, fmt.Errorf("sine needs a positive periodS")
+		}
+		// Starts at MinClients, peaks at MaxClients half a period later.
+		for t := time.Duration(0); t < duration; t += interval {
+			phase := 2 * math.Pi * float64(t) / float64(period)
+			n := c.MinClients + int(math.Round(float64(c.MaxClients-c.MinClients)*(1-math.Cos(phase))/2))
+			plan = append(plan, stagePlan{n, interval})
+		}
+	case "custom":
+		for _, s := range p.Stages {
+			plan = append(plan, stagePlan{s.Clients, time.Duration(s.DurationS) * time.Second})
+		}
+	default:
+		return nil, fmt.Errorf("unknown load profile %q", p.Type)
+	}
+
+	if len(plan) == 0 {
+		return nil, fmt.Errorf("profile %q has no stages", p.Type)
+	}
+	for i, s := range plan {
+		if s.Clients < 1 || s.Duration <= 0 {
+			return nil, fmt.Errorf("profile %q: stage %d needs at least one client and a positive duration", p.Type, i+1)
+		}
+	}
+	return plan, nil
+}
+
+// appendHold adds stages of at most interval that keep clients constant for
+// total.
+func appendHold(plan []stagePlan, clients int, total, interval time.Duration) []stagePlan {
+	for total > 0 {
+		d := min(interval, total)
+		plan = append(plan, stagePlan{clients, d})
+		total -= d
+	}
+	return plan
+}
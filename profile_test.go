@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStages(t *testing.T) {
+	s := time.Second
+	tests := []struct {
+		name string
+		c    TestConfig
+		want []stagePlan
+	}{
+		{
+			name: "step",
+			c:    TestConfig{MinClients: 1, MaxClients: 10, StageIntervalS: 5, Profile: ProfileConfig{Type: "step", Increment: 3}},
+			want: []stagePlan{{1, 5 * s}, {4, 5 * s}, {7, 5 * s}, {10, 5 * s}},
+		},
+		{
+			name: "step defaults to one client increments",
+			c:    TestConfig{MinClients: 1, MaxClients: 3, StageIntervalS: 5},
+			want: []stagePlan{{1, 5 * s}, {2, 5 * s}, {3, 5 * s}},
+		},
+		{
+			name: "ramp",
+			c:    TestConfig{MinClients: 2, MaxClients: 10, StageIntervalS: 5, Profile: ProfileConfig{Type: "ramp", DurationS: 25}},
+			want: []stagePlan{{2, 5 * s}, {4, 5 * s}, {6, 5 * s}, {8, 5 * s}, {10, 5 * s}},
+		},
+		{
+			name: "spike",
+			c:    TestConfig{MinClients: 2, MaxClients: 20, StageIntervalS: 10, Profile: ProfileConfig{Type: "spike", SpikeS: 25, RecoveryS: 10}},
+			want: []stagePlan{{2, 10 * s}, {20, 10 * s}, {20, 10 * s}, {20, 5 * s}, {2, 10 * s}},
+		},
+		{
+			name: "soak",
+			c:    TestConfig{MinClients: 1, MaxClients: 8, StageIntervalS: 10, Profile: ProfileConfig{Type: "soak", DurationS: 25}},
+			want: []stagePlan{{8, 10 * s}, {8, 10 * s}, {8, 5 * s}},
+		},
+		{
+			name: "sine",
+			c:    TestConfig{MinClients: 2, MaxClients: 10, StageIntervalS: 10, Profile: ProfileConfig{Type: "sine", DurationS: 40, PeriodS: 40}},
+			want: []stagePlan{{2, 10 * s}, {6, 10 * s}, {10, 10 * s}, {6, 10 * s}},
+		},
+		{
+			name: "custom",
+			c:    TestConfig{Profile: ProfileConfig{Type: "custom", Stages: []StageConfig{{Clients: 3, DurationS: 7}, {Clients: 5, DurationS: 2}}}},
+			want: []stagePlan{{3, 7 * s}, {5, 2 * s}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.c.stages()
+			if err != nil {
+				t.Fatalf("stages() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStagesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		c    TestConfig
+	}{
+		{"unknown profile", TestConfig{MinClients: 1, MaxClients: 2, StageIntervalS: 5, Profile: ProfileConfig{Type: "square"}}},
+		{"missing stage interval", TestConfig{MinClients: 1, MaxClients: 2, Profile: ProfileConfig{Type: "step"}}},
+		{"ramp shorter than two stages", TestConfig{MinClients: 1, MaxClients: 2, StageIntervalS: 5, Profile: ProfileConfig{Type: "ramp", DurationS: 5}}},
+		{"sine without period", TestConfig{MinClients: 1, MaxClients: 2, StageIntervalS: 5, Profile: ProfileConfig{Type: "sine", DurationS: 20}}},
+		{"step without clients", TestConfig{MinClients: 0, MaxClients: 2, StageIntervalS: 5}},
+		{"custom stage without duration", TestConfig{Profile: ProfileConfig{Type: "custom", Stages: []StageConfig{{Clients: 3}}}}},
+		{"custom without stages", TestConfig{Profile: ProfileConfig{Type: "custom"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if plan, err := tt.c.stages(); err == nil {
+				t.Errorf("stages() = %v, want an error", plan)
+			}
+		})
+	}
+}
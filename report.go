@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// runResult is the in-process record of a whole benchmark run, written out
+// once the last stage of every backend has finished.
+type runResult struct {
+	Started  time.Time       `json:"started"`
+	Finished time.Time       `json:"finished"`
+	Workload string          `json:"workload,omitempty"`
+	Mode     string          `json:"mode,omitempty"`
+	Backends []backendResult `json:"backends"`
+}
+
+type backendResult struct {
+	Name     string            `json:"name"`
+	Settings map[string]string `json:"settings,omitempty"`
+	Load     *preloadResult    `json:"load,omitempty"`
+	Stages   []stageResult     `json:"stages"`
+}
+
+// preloadResult describes the preload that ran before the stages.
+type preloadResult struct {
+	Records     int     `json:"records"`
+	Errors      int64   `json:"errors"`
+	IngestS     float64 `json:"ingestS"`
+	Throughput  float64 `json:"throughput"`
+	IndexBuildS float64 `json:"indexBuildS"`
+	TotalS      float64 `json:"totalS"`
+	// DatasetRecords and DatasetBytes are measured once the indexes are
+	// built.
+	DatasetRecords int64 `json:"datasetRecords"`
+	DatasetBytes   int64 `json:"datasetBytes"`
+}
+
+type stageResult struct {
+	Stage     int     `json:"stage"`
+	Clients   int     `json:"clients"`
+	DurationS float64 `json:"durationS"`
+	// TargetRate is the scheduled open-loop rate; zero for closed loop.
+	TargetRate float64 `json:"targetRate,omitempty"`
+	// DatasetSize is the number of stored projects the stage ran against
+	// in a dataset-size sweep.
+	DatasetSize int64      `json:"datasetSize,omitempty"`
+	Ops         []opResult `json:"ops"`
+}
+
+// opResult is the report row of one op. Nested is the part of Count that
+// ran inside another operation, like the read and update of a
+// read_modify_write.
+type opResult struct {
+	Op         string  `json:"op"`
+	Count      int     `json:"count"`
+	Errors     int     `json:"errors"`
+	Nested     int     `json:"nested,omitempty"`
+	Throughput float64 `json:"throughput"`
+	MeanMs     float64 `json:"meanMs"`
+	MinMs      float64 `json:"minMs"`
+	P50Ms      float64 `json:"p50Ms"`
+	P90Ms      float64 `json:"p90Ms"`
+	P95Ms      float64 `json:"p95Ms"`
+	P99Ms      float64 `json:"p99Ms"`
+	P999Ms     float64 `json:"p999Ms"`
+	MaxMs      float64 `json:"maxMs"`
+}
+
+func newStageResult(stage, clients int, sum stageSummary) stageResult {
+	sr := stageResult{Stage: stage, Clients: clients, DurationS: sum.Duration.Seconds()}
+	for _, op := range sum.opNames() {
+		sr.Ops = append(sr.Ops, sum.result(op))
+	}
+	return sr
+}
+
+func (r *runResult) sortBackends() {
+	sort.Slice(r.Backends, func(i, j int) bool { return r.Backends[i].Name < r.Backends[j].Name })
+}
+
+var reportWriters = map[string]func(io.Writer, *runResult) error{
+	"json": writeJSON,
+	"csv":  writeCSV,
+	"md":   writeMarkdown,
+	"ycsb": writeYCSB,
+}
+
+var csvHeader = []string{"db", "stage", "clients", "dataset_size", "op", "count", "errors", "throughput", "mean_ms", "p50_ms", "p90_ms", "p99_ms", "p999_ms", "max_ms"}
+
+// writeReport stores r in every configured format under cfg.Dir and, when
+// configured, exports it for LaTeX.
+func writeReport(cfg ReportConfig, r *runResult) error {
+	if cfg.Dir != "" {
+		if err := writeFormats(cfg.Dir, cfg.Formats, r); err != nil {
+			return err
+		}
+	}
+	if cfg.LatexDir != "" {
+		return writeLatex(cfg.LatexDir, r)
+	}
+	return nil
+}
+
+// writeFormats writes one file per format; files share the run start time
+// as their base name.
+func writeFormats(dir string, formats []string, r *runResult) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("unable to create report directory: %w", err)
+	}
+	base := filepath.Join(dir, r.Started.Format("20060102-150405"))
+	for _, format := range formats {
+		write, ok := reportWriters[format]
+		if !ok {
+			return fmt.Errorf("unknown report format %q", format)
+		}
+		path := base + "." + format
+		if err := writeFile(path, r, write); err != nil {
+			return err
+		}
+		slog.Info("Report written", "path", path)
+	}
+	return nil
+}
+
+func writeFile(path string, r *runResult, write func(io.Writer, *runResult) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+func loadResult(path string) (*runResult, error) {
+	f, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := new(runResult)
+	if err := json.Unmarshal(f, r); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	return r, nil
+}
+
+func writeJSON(w io.Writer, r *runResult) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func writeCSV(w io.Writer, r *runResult) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, b := range r.Backends {
+		for _, s := range b.Stages {
+			for _, o := range s.Ops {
+				cw.Write([]string{
+					b.Name, strconv.Itoa(s.Stage), strconv.Itoa(s.Clients), strconv.FormatInt(s.DatasetSize, 10), o.Op,
+					strconv.Itoa(o.Count), strconv.Itoa(o.Errors), formatFloat(o.Throughput),
+					formatFloat(o.MeanMs), formatFloat(o.P50Ms), formatFloat(o.P90Ms),
+					formatFloat(o.P99Ms), formatFloat(o.P999Ms), formatFloat(o.MaxMs),
+				})
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeMarkdown(w io.Writer, r *runResult) error {
+	fmt.Fprintf(w, "# Benchmark report\n\nWorkload %s (%s loop), started %s, finished %s.\n", r.Workload, loopMode(r.Mode), r.Started.Format(time.RFC3339), r.Finished.Format(time.RFC3339))
+	for _, b := range r.Backends {
+		fmt.Fprintf(w, "\n## %s\n\n", b.Name)
+		if len(b.Settings) > 0 {
+			keys := make([]string, 0, len(b.Settings))
+			for k := range b.Settings {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Fprintf(w, "- %s: %s\n", k, b.Settings[k])
+			}
+			fmt.Fprintln(w)
+		}
+		if l := b.Load; l != nil {
+			fmt.Fprintf(w, "Preload: %d records (%d errors) in %ss, %s records/s, index build %ss, total %ss. Dataset: %d records, %s MiB.\n\n",
+				l.Records, l.Errors, formatFloat(l.IngestS), formatFloat(l.Throughput), formatFloat(l.IndexBuildS),
+				formatFloat(l.TotalS), l.DatasetRecords, formatFloat(float64(l.DatasetBytes)/(1<<20)))
+		}
+		fmt.Fprintln(w, "| stage | clients | op | count | errors | op/s | mean ms | p50 ms | p90 ms | p99 ms | p99.9 ms | max ms |")
+		fmt.Fprintln(w, "|---:|---:|---|---:|---:|---:|---:|---:|---:|---:|---:|---:|")
+		for _, s := range b.Stages {
+			for _, o := range s.Ops {
+				fmt.Fprintf(w, "| %d | %d | %s | %d | %d | %s | %s | %s | %s | %s | %s | %s |\n",
+					s.Stage, s.Clients, o.Op, o.Count, o.Errors, formatFloat(o.Throughput),
+					formatFloat(o.MeanMs), formatFloat(o.P50Ms), formatFloat(o.P90Ms),
+					formatFloat(o.P99Ms), formatFloat(o.P999Ms), formatFloat(o.MaxMs))
+			}
+		}
+		writeSweep(w, b)
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// writeSweep adds the latency of every op against the dataset size when
+// the stages of b come from a dataset-size sweep.
+func writeSweep(w io.Writer, b backendResult) {
+	if len(b.Stages) == 0 || b.Stages[0].DatasetSize == 0 {
+		return
+	}
+	fmt.Fprint(w, "\n### Latency vs dataset size\n\n")
+	fmt.Fprintln(w, "| op | dataset | stage | clients | op/s | mean ms | p50 ms | p99 ms | p99.9 ms |")
+	fmt.Fprintln(w, "|---|---:|---:|---:|---:|---:|---:|---:|---:|")
+	for _, op := range backendOps(b) {
+		for _, s := range b.Stages {
+			for _, o := range s.Ops {
+				if o.Op != op {
+					continue
+				}
+				fmt.Fprintf(w, "| %s | %d | %d | %d | %s | %s | %s | %s | %s |\n",
+					o.Op, s.DatasetSize, s.Stage, s.Clients, formatFloat(o.Throughput),
+					formatFloat(o.MeanMs), formatFloat(o.P50Ms), formatFloat(o.P99Ms), formatFloat(o.P999Ms))
+			}
+		}
+	}
+}
+
+func loopMode(mode string) string {
+	if mode == "" {
+		return "closed"
+	}
+	return mode
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 3, 64)
+}
+
+// writeComparison renders a Markdown table of every backend, stage and op
+// present in both results with the relative change from base to next.
+func writeComparison(w io.Writer, base, next *runResult) error {
+	type key struct {
+		db    string
+		stage int
+		op    string
+	}
+	before := map[key]opResult{}
+	for _, b := range base.Backends {
+		for _, s := range b.Stages {
+			for _, o := range s.Ops {
+				before[key{b.Name, s.Stage, o.Op}] = o
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "# Comparison\n\nBase started %s, new started %s.\n", base.Started.Format(time.RFC3339), next.Started.Format(time.RFC3339))
+	for _, b := range next.Backends {
+		fmt.Fprintf(w, "\n## %s\n\n", b.Name)
+		fmt.Fprintln(w, "| stage | clients | op | op/s base | op/s new | Δ op/s | p50 ms base | p50 ms new | Δ p50 | p99 ms base | p99 ms new | Δ p99 |")
+		fmt.Fprintln(w, "|---:|---:|---|---:|---:|---:|---:|---:|---:|---:|---:|---:|")
+		for _, s := range b.Stages {
+			for _, o := range s.Ops {
+				old, ok := before[key{b.Name, s.Stage, o.Op}]
+				if !ok {
+					continue
+				}
+				fmt.Fprintf(w, "| %d | %d | %s | %s | %s | %s | %s | %s | %s | %s | %s | %s |\n",
+					s.Stage, s.Clients, o.Op,
+					formatFloat(old.Throughput), formatFloat(o.Throughput), formatChange(old.Throughput, o.Throughput),
+					formatFloat(old.P50Ms), formatFloat(o.P50Ms), formatChange(old.P50Ms, o.P50Ms),
+					formatFloat(old.P99Ms), formatFloat(o.P99Ms), formatChange(old.P99Ms, o.P99Ms))
+			}
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+func formatChange(before, after float64) string {
+	if before == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f%%", (after-before)/before*100)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// stageStats accumulates operation outcomes for the stage that is currently
+// running, so throughput can be reported without querying Prometheus.
+type stageStats struct {
+	mu    sync.Mutex
+	start time.Time
+	ops   map[string]*opCount
+}
+
+// opCount tallies one op. Nested counts the outcomes that were part of
+// another operation and are left out of stage totals.
+type opCount struct {
+	Ok           int
+	Failed       int
+	NestedOk     int
+	NestedFailed int
+	latencies    []time.Duration
+}
+
+// stageSummary is a snapshot of a finished stage.
+type stageSummary struct {
+	Duration time.Duration
+	Ops      map[string]opCount
+}
+
+func newStageStats() *stageStats {
+	return &stageStats{start: time.Now(), ops: make(map[string]*opCount)}
+}
+
+func (s *stageStats) record(op string, elapsed time.Duration, err error, nested bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.ops[op]
+	if !ok {
+		c = new(opCount)
+		s.ops[op] = c
+	}
+	if err != nil {
+		c.Failed++
+		if nested {
+			c.NestedFailed++
+		}
+	} else {
+		c.Ok++
+		if nested {
+			c.NestedOk++
+		}
+		c.latencies = append(c.latencies, elapsed)
+	}
+}
+
+// reset returns the counts gathered since the previous reset and starts a
+// new stage.
+func (s *stageStats) reset() stageSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	sum := stageSummary{Duration: now.Sub(s.start), Ops: make(map[string]opCount, len(s.ops))}
+	for op, c := range s.ops {
+		sum.Ops[op] = *c
+	}
+	s.start = now
+	s.ops = make(map[string]*opCount)
+	return sum
+}
+
+func (s stageSummary) opNames() []string {
+	names := make([]string, 0, len(s.Ops))
+	for op := range s.Ops {
+		names = append(names, op)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// throughput returns successful operations per second for op, or for all
+// top-level operations when op is empty.
+func (s stageSummary) throughput(op string) float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	var ok int
+	if op == "" {
+		ok, _ = s.totals()
+	} else {
+		ok = s.Ops[op].Ok
+	}
+	return float64(ok) / s.Duration.Seconds()
+}
+
+// totals returns the successful and failed top-level operations; nested
+// steps are already counted by the operation they belong to.
+func (s stageSummary) totals() (ok, failed int) {
+	for _, c := range s.Ops {
+		ok += c.Ok - c.NestedOk
+		failed += c.Failed - c.NestedFailed
+	}
+	return ok, failed
+}
+
+// result computes the report row for op. Latencies are only taken from
+// successful operations, mirroring crud_latency_seconds.
+func (s stageSummary) result(op string) opResult {
+	c := s.Ops[op]
+	r := opResult{Op: op, Count: c.Ok, Errors: c.Failed, Nested: c.NestedOk, Throughput: s.throughput(op)}
+	if len(c.latencies) == 0 {
+		return r
+	}
+	lat := make([]time.Duration, len(c.latencies))
+	copy(lat, c.latencies)
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+
+	var total time.Duration
+	for _, d := range lat {
+		total += d
+	}
+	r.MeanMs = ms(total / time.Duration(len(lat)))
+	r.MinMs = ms(lat[0])
+	r.P50Ms = ms(percentile(lat, 50))
+	r.P90Ms = ms(percentile(lat, 90))
+	r.P95Ms = ms(percentile(lat, 95))
+	r.P99Ms = ms(percentile(lat, 99))
+	r.P999Ms = ms(percentile(lat, 99.9))
+	r.MaxMs = ms(lat[len(lat)-1])
+	return r
+}
+
+// percentile returns the nearest-rank percentile of sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	ten := make([]time.Duration, 10)
+	for i := range ten {
+		ten[i] = time.Duration(i+1) * time.Millisecond
+	}
+	tests := []struct {
+		name   string
+		sorted []time.Duration
+		p      float64
+		want   time.Duration
+	}{
+		{"p0 is the minimum", ten, 0, 1 * time.Millisecond},
+		{"p50", ten, 50, 5 * time.Millisecond},
+		{"p90", ten, 90, 9 * time.Millisecond},
+		{"p99 rounds up", ten, 99, 10 * time.Millisecond},
+		{"p100 is the maximum", ten, 100, 10 * time.Millisecond},
+		{"single sample", []time.Duration{3 * time.Millisecond}, 99.9, 3 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.sorted, tt.p); got != tt.want {
+				t.Errorf("percentile(p%g) = %v, want %v", tt.p, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,262 +1,83 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
-	"encoding/json"
 	"time"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-type project struct {
-	PostgresId      int      `bson:"-" json:"-"`
-	MongoId         string   `bson:"-" json:"-"`
-	ElasticsearchId string   `bson:"-" json:"-"`
-	Id              any      `bson:"_id,omitempty" json:"id,omitempty"`
-	Price           float32  `bson:"price,omitempty" json:"price,omitempty"`
-	TextContent     string   `bson:"textContent,omitempty" json:"textContent,omitempty"`
-}
+// ftsKeyword is the term every backend searches for in searchFTS unless the
+// workload sets another one.
+const ftsKeyword = "mongodb"
+
+// defaultTextWords is the length of generated textContent in words.
+const defaultTextWords = 10000
 
-func (p *project) create(pg *postgres, mg *mongodb, es *elastic, db string, m *metrics) error {
-       defer observeLatency(m, "create", time.Now())
-       switch db {
-       case "pg":
-	       b, err := json.Marshal(p)
-	       if err != nil {
-		       return err
-	       }
-	       return pg.dbpool.QueryRow(pg.context, `INSERT INTO project(jdoc) VALUES ($1) RETURNING id`, b).Scan(&p.PostgresId)
-       case "mg":
-	       res, err := mg.db.Collection("project").InsertOne(mg.context, p)
-	       if err == nil {
-		       if oid, ok := res.InsertedID.(primitive.ObjectID); ok {
-			       p.MongoId = oid.Hex()
-		       }
-	       }
-	       return err
-       case "es":
-	       b, err := json.Marshal(p)
-	       if err != nil {
-		       return err
-	       }
-	       id, err := es.EnqueueBulk("index", es.Cfg.IndexName, p.ElasticsearchId, b)
-	       if err != nil {
-		       res, err := es.client.Index(es.Cfg.IndexName, bytes.NewReader(b), es.client.Index.WithDocumentID(p.ElasticsearchId), es.client.Index.WithContext(es.context))
-		       if err != nil {
-			       return err
-		       }
-		       defer res.Body.Close()
-		       if !res.IsError() {
-			       var r map[string]interface{}
-			       if err := json.NewDecoder(res.Body).Decode(&r); err == nil {
-				       if id2, ok := r["_id"].(string); ok {
-					       p.ElasticsearchId = id2
-				       }
-			       }
-		       }
-		       return nil
-	       }
-	       if id != "" {
-		       p.ElasticsearchId = id
-	       }
-	       return nil
-       }
-       return nil
+// searchQuery carries the parameters of the search and searchFTS
+// operations.
+type searchQuery struct {
+	MinPrice float64
+	MaxPrice float64
+	Limit    int
+	Keyword  string
 }
 
-func (p *project) update(pg *postgres, mg *mongodb, es *elastic, db string, m *metrics) error {
-       defer observeLatency(m, "update", time.Now())
-       switch db {
-       case "pg":
-	       _, err := pg.dbpool.Exec(pg.context, `UPDATE project SET jdoc = jsonb_set(jdoc, '{price}', $1) WHERE id = $2`, p.Price, p.PostgresId)
-	       return err
-       case "mg":
-	       id, err := primitive.ObjectIDFromHex(p.MongoId)
-	       if err != nil {
-		       return err
-	       }
-	       filter := bson.M{"_id": id}
-	       update := bson.M{"$set": bson.M{"price": p.Price}}
-	       _, err = mg.db.Collection("project").UpdateOne(mg.context, filter, update)
-	       return err
-       case "es":
-	       doc := map[string]interface{}{"doc": map[string]interface{}{"price": p.Price}}
-	       var buf bytes.Buffer
-	       if err := json.NewEncoder(&buf).Encode(doc); err != nil {
-		       return err
-	       }
-	       _, err := es.EnqueueBulk("update", es.Cfg.IndexName, p.ElasticsearchId, buf.Bytes())
-	       if err != nil {
-		       res, err := es.client.Update(es.Cfg.IndexName, p.ElasticsearchId, &buf, es.client.Update.WithContext(es.context), es.client.Update.WithRefresh("false"))
-		       if err == nil {
-			       defer res.Body.Close()
-			       if !res.IsError() {
-				       return nil
-			       }
-		       }
-	       }
-	       return nil
-       }
-       return nil
+type project struct {
+	PostgresId      int     `bson:"-" json:"-"`
+	MongoId         string  `bson:"-" json:"-"`
+	ElasticsearchId string  `bson:"-" json:"-"`
+	Id              any     `bson:"_id,omitempty" json:"id,omitempty"`
+	Price           float32 `bson:"price,omitempty" json:"price,omitempty"`
+	TextContent     string  `bson:"textContent,omitempty" json:"textContent,omitempty"`
 }
 
-func (p *project) search(pg *postgres, mg *mongodb, es *elastic, db string, m *metrics) error {
-       defer observeLatency(m, "search", time.Now())
-       switch db {
-       case "pg":
-	       var avg sql.NullFloat64
-	       err := pg.dbpool.QueryRow(pg.context, `SELECT AVG(price) FROM (SELECT (jdoc -> 'price')::numeric as price FROM project WHERE (jdoc -> 'price')::numeric < $1 LIMIT 200) as limited_projects`, 30).Scan(&avg)
-	       if err != nil && err != sql.ErrNoRows {
-		       return err
-	       }
-	       _ = avg
-	       return nil
-       case "mg":
-	       pipeline := []bson.M{
-		       {"$match": bson.M{"price": bson.M{"$lt": 30}}},
-		       {"$limit": 200},
-		       {"$group": bson.M{"_id": nil, "avg_price": bson.M{"$avg": "$price"}}},
-	       }
-	       cursor, err := mg.db.Collection("project").Aggregate(mg.context, pipeline)
-	       if err != nil {
-		       return err
-	       }
-	       defer cursor.Close(mg.context)
-	       var out struct{
-		       AvgPrice float64 `bson:"avg_price"`
-	       }
-	       if cursor.Next(mg.context) {
-		       if err := cursor.Decode(&out); err != nil {
-			       return err
-		       }
-	       }
-	       _ = out
-	       return nil
-       case "es":
-	       var buf bytes.Buffer
-	       query := map[string]interface{}{
-		       "query": map[string]interface{}{
-			       "range": map[string]interface{}{"price": map[string]interface{}{"lt": 30}},
-		       },
-		       "size": 0,
-		       "aggs": map[string]interface{}{
-			       "avg_price": map[string]interface{}{"avg": map[string]interface{}{"field": "price"}},
-		       },
-	       }
-	       if err := json.NewEncoder(&buf).Encode(query); err != nil {
-		       return err
-	       }
-	       res, err := es.client.Search(
-		       es.client.Search.WithContext(es.context),
-		       es.client.Search.WithIndex(es.Cfg.IndexName),
-		       es.client.Search.WithBody(&buf),
-	       )
-	       if err != nil {
-		       return err
-	       }
-	       defer res.Body.Close()
-	       var r map[string]interface{}
-	       if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
-		       return err
-	       }
-	       if aggs, ok := r["aggregations"].(map[string]interface{}); ok {
-		       if ap, ok := aggs["avg_price"].(map[string]interface{}); ok {
-			       _ = ap["value"]
-		       }
-	       }
-	       return nil
-       }
-       return nil
+func newProject(words int) project {
+	return project{
+		Price:       float32(random(1, 100)),
+		TextContent: generateFTSContent(words),
+	}
 }
 
-func (p *project) searchFTS(pg *postgres, mg *mongodb, es *elastic, db string, m *metrics) error {
-	defer observeLatency(m, "search_fts", time.Now())
-	
-	keyword := "mongodb" 
+// The operation wrappers below record latency from start, which is the
+// moment the operation was due: time.Now() for closed-loop clients and the
+// scheduled arrival for open-loop ones.
+
+func (p *project) create(b Backend, m *metrics, start time.Time) error {
+	err := b.Create(p)
+	observeLatency(m, "create", start, err)
+	return err
+}
 
-	switch db {
-	case "pg":
-		var count sql.NullInt64
-		err := pg.dbpool.QueryRow(pg.context,
-			`SELECT COUNT(*) FROM project 
-			 WHERE to_tsvector('simple', jdoc ->> 'textContent') @@ to_tsquery('simple', $1)`,
-			keyword).Scan(&count)
-		if err != nil && err != sql.ErrNoRows {
-			return err
-		}
-		_ = count
-		return nil
+func (p *project) read(b Backend, m *metrics, start time.Time) error {
+	err := b.Read(p)
+	observeLatency(m, "read", start, err)
+	return err
+}
 
-	case "mg":
-		filter := bson.M{"$text": bson.M{"$search": keyword}}
-		count, err := mg.db.Collection("project").CountDocuments(mg.context, filter)
-		if err != nil {
-			return err
-		}
-		_ = count
-		return nil
+func (p *project) update(b Backend, m *metrics, start time.Time) error {
+	err := b.Update(p)
+	observeLatency(m, "update", start, err)
+	return err
+}
 
-	case "es":
-		var buf bytes.Buffer
-		query := map[string]interface{}{
-			"query": map[string]interface{}{
-				"match": map[string]interface{}{
-					"textContent": keyword,
-				},
-			},
-		}
-		if err := json.NewEncoder(&buf).Encode(query); err != nil {
-			return err
-		}
+func search(b Backend, m *metrics, q searchQuery, start time.Time) error {
+	err := b.Search(q)
+	observeLatency(m, "search", start, err)
+	return err
+}
 
-		res, err := es.client.Count(
-			es.client.Count.WithContext(es.context),
-			es.client.Count.WithIndex(es.Cfg.IndexName),
-			es.client.Count.WithBody(&buf),
-		)
-		if err != nil {
-			return err
-		}
-		defer res.Body.Close()
+func searchFTS(b Backend, m *metrics, q searchQuery, start time.Time) error {
+	err := b.SearchFTS(q)
+	observeLatency(m, "search_fts", start, err)
+	return err
+}
 
-		var r map[string]interface{}
-		if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
-			return err
-		}
-		_ = r
-		return nil
-	}
-	return nil
+func (p *project) delete(b Backend, m *metrics, start time.Time) error {
+	err := b.Delete(p)
+	observeLatency(m, "delete", start, err)
+	return err
 }
 
-func (p *project) delete(pg *postgres, mg *mongodb, es *elastic, db string, m *metrics) error {
-       defer observeLatency(m, "delete", time.Now())
-       switch db {
-       case "pg":
-	       _, err := pg.dbpool.Exec(pg.context, `DELETE FROM project WHERE id = $1`, p.PostgresId)
-	       return err
-       case "mg":
-	       id, err := primitive.ObjectIDFromHex(p.MongoId)
-	       if err != nil {
-		       return err
-	       }
-	       filter := bson.M{"_id": id}
-	       _, err = mg.db.Collection("project").DeleteOne(mg.context, filter)
-	       return err
-       case "es":
-	       _, err := es.EnqueueBulk("delete", es.Cfg.IndexName, p.ElasticsearchId, nil)
-	       if err != nil {
-		       res, err := es.client.Delete(es.Cfg.IndexName, p.ElasticsearchId, es.client.Delete.WithContext(es.context), es.client.Delete.WithRefresh("false"))
-		       if err == nil {
-			       defer res.Body.Close()
-			       if !res.IsError() {
-				       return nil
-			       }
-		       }
-	       }
-	       return nil
-       }
-       return nil
-}
\ No newline at end of file
+func scan(b Backend, m *metrics, q searchQuery, start time.Time) error {
+	err := b.Scan(q)
+	observeLatency(m, "scan", start, err)
+	return err
+}
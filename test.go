@@ -2,71 +2,216 @@ package main
 
 import (
 	"context"
-	"sync"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
 	"time"
 )
 
-func runTest(cfg *Config, dbType string, m *metrics) {
-       ctx, done := context.WithCancel(context.Background())
-       defer done()
-
-       var pg *postgres
-       var mg *mongodb
-       var es *elastic
-       switch dbType {
-       case "pg":
-           pg = NewPostgres(cfg)
-       case "mg":
-           mg = NewMongo(cfg)
-       case "es":
-           es, _ = NewElasticsearch(ctx, cfg, m)
-       }
-
-       sleepInterval := time.Duration(cfg.Test.RequestDelayMs) * time.Millisecond
-       for currentClients := cfg.Test.MinClients; currentClients <= cfg.Test.MaxClients; currentClients++ {
-           m.clients.WithLabelValues(dbType, "stage").Set(float64(currentClients))
-           stageCtx, cancelStage := context.WithCancel(ctx)
-           var stageWG sync.WaitGroup
-           for i := 0; i < currentClients; i++ {
-               stageWG.Add(1)
-               go func() {
-                   defer stageWG.Done()
-                   for {
-                       select {
-                       case <-stageCtx.Done():
-                           return
-                       default:
-                       }
-                       p1 := project{
-                           Price:       float32(random(1, 100)),
-                           TextContent: generateFTSContent(),
-                       }
-                       p2 := project{
-                           Price:       float32(random(1, 100)),
-                           TextContent: generateFTSContent(),
-                       }
-
-                       _ = p1.create(pg, mg, es, dbType, m)
-                       _ = p2.create(pg, mg, es, dbType, m)
-
-                       p1.Price = float32(random(1, 100))
-                       _ = p1.update(pg, mg, es, dbType, m)
-
-                       _ = p1.searchFTS(pg, mg, es, dbType, m)
-
-                       _ = p2.delete(pg, mg, es, dbType, m)
-
-                       if sleepInterval > 0 {
-                           select {
-                           case <-time.After(sleepInterval):
-                           case <-stageCtx.Done():
-                           }
-                       }
-                   }
-               }()
-           }
-           time.Sleep(time.Duration(cfg.Test.StageIntervalS) * time.Second)
-           cancelStage()
-           stageWG.Wait()
-       }
-}
\ No newline at end of file
+func runTest(cfg *Config, b Backend, dbType string, m *metrics) backendResult {
+	wl, err := newWorkload(cfg.Workload)
+	fail(err, "Invalid workload")
+	plan, err := cfg.Test.stages()
+	fail(err, "Invalid load profile")
+	fail(wl.checkRates(len(plan)), "Invalid workload")
+	if len(cfg.Test.DatasetSizes) > 0 {
+		for _, op := range wl.ops {
+			if op.Op == "delete" {
+				fail(fmt.Errorf("delete cannot be combined with datasetSizes"), "Invalid workload")
+			}
+		}
+	}
+
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+
+	fail(b.Connect(ctx, m), "Unable to connect to %s", dbType)
+	defer b.Close()
+	if cfg.Test.HealthTimeoutS > 0 {
+		fail(waitHealthy(b, dbType, time.Duration(cfg.Test.HealthTimeoutS)*time.Second), "Health check failed")
+	}
+	fail(b.Bootstrap(cfg.Recreate), "Unable to bootstrap %s", dbType)
+
+	// A sweep keeps every loaded project in the keyspace so that point
+	// operations spread over the whole dataset, not just recent creates.
+	var ks *keyspace
+	var keep func(project)
+	if len(cfg.Test.DatasetSizes) > 0 {
+		ks = new(keyspace)
+		keep = ks.add
+	}
+
+	result := backendResult{Name: dbType, Settings: backendSettings(b)}
+	m.setPhase(phaseLoad)
+	if cfg.Load.Preload {
+		result.Load, err = preload(cfg.Load, b, dbType, keep)
+		fail(err, "Unable to preload %s", dbType)
+	}
+
+	if wl.recordCount > 0 {
+		if ks == nil {
+			ks = new(keyspace)
+		}
+		start := time.Now()
+		failed := loadData(b, wl.recordCount, cfg.Load, ks.add)
+		slog.Info("Records loaded", "db", dbType, "records", ks.size(), "errors", failed, "duration", time.Since(start).Round(time.Millisecond))
+	}
+	budget := newOpBudget(wl.operationCount)
+
+	var arr atomic.Pointer[arrivals]
+	workers := newPool(ctx, func(ctx context.Context) {
+		newWorker(b, m, wl, ks, budget, &arr).loop(ctx)
+	})
+	defer workers.stop()
+
+	// Without dataset sizes the stages run once against whatever the
+	// backend holds; otherwise once per size, each after growing the
+	// dataset to it.
+	sizes := cfg.Test.DatasetSizes
+	if len(sizes) == 0 {
+		sizes = []int{0}
+	}
+	for _, size := range sizes {
+		var records int64
+		if size > 0 {
+			// Operations still in flight would overlap the load.
+			workers.stop()
+			// Every size runs the full operation count; the workers
+			// started below pick up the new budget.
+			budget = newOpBudget(wl.operationCount)
+			m.setPhase(phaseLoad)
+			records, err = growDataset(cfg.Load, b, dbType, size, ks.add)
+			fail(err, "Unable to grow %s dataset", dbType)
+			if a := arr.Load(); a != nil {
+				a.restart()
+			}
+			// Documents that were already stored have unknown ids, so
+			// point operations could only fall back to creates.
+			if wl.targetsRecords() {
+				if ks.size() == 0 {
+					fail(fmt.Errorf("none of its %d records were loaded by this run", records),
+						"Empty keyspace for %s dataset size %d, rerun with --recreate", dbType, size)
+				}
+				if int64(ks.size()) < records {
+					slog.Warn("Keyspace covers part of the dataset", "db", dbType, "size", size, "records", records, "keyspace", ks.size())
+				}
+			}
+		}
+
+		if cfg.Test.Warmup != (PhaseConfig{}) {
+			m.setPhase(phaseWarmup)
+			if wl.open {
+				scheduleRate(&arr, wl.stageRate(0), wl.poisson)
+			}
+			workers.resize(plan[0].Clients)
+			runPhase(dbType, m, phaseWarmup, cfg.Test.Warmup)
+		}
+
+		m.setPhase(phaseMeasure)
+		m.stats.reset()
+		stages := runStages(dbType, m, wl, plan, workers, &arr, budget, len(result.Stages))
+		for i := range stages {
+			stages[i].DatasetSize = records
+		}
+		result.Stages = append(result.Stages, stages...)
+	}
+
+	if cfg.Test.Cooldown != (PhaseConfig{}) {
+		m.setPhase(phaseCooldown)
+		if budgetSpent(budget) {
+			// Workers return for good once the operation count is spent,
+			// so the cooldown needs a fresh set.
+			workers.stop()
+			if a := arr.Load(); a != nil {
+				a.restart()
+			}
+			workers.resize(plan[len(plan)-1].Clients)
+		}
+		runPhase(dbType, m, phaseCooldown, cfg.Test.Cooldown)
+	}
+	return result
+}
+
+// runPhase lets the pool run unmeasured until the phase duration elapses or
+// its operation count completes.
+func runPhase(dbType string, m *metrics, phase string, c PhaseConfig) {
+	slog.Info("Phase started", "db", dbType, "phase", phase, "durationS", c.DurationS, "ops", c.Ops)
+	var deadline <-chan time.Time
+	if c.DurationS > 0 {
+		deadline = time.After(time.Duration(c.DurationS) * time.Second)
+	}
+	start := m.completed.Load()
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-deadline:
+			return
+		case <-tick.C:
+			if c.Ops > 0 && m.completed.Load()-start >= int64(c.Ops) {
+				return
+			}
+		}
+	}
+}
+
+// runStages runs plan and numbers its stages from first+1.
+func runStages(dbType string, m *metrics, wl *workload, plan []stagePlan, workers *pool, arr *atomic.Pointer[arrivals], budget *opBudget, first int) []stageResult {
+	var stages []stageResult
+	for i, stage := range plan {
+		id := first + len(stages) + 1
+		var rate float64
+		if wl.open {
+			rate = wl.stageRate(i)
+			scheduleRate(arr, rate, wl.poisson)
+		}
+		m.setStage(id)
+		workers.resize(stage.Clients)
+		m.clients.WithLabelValues(dbType, "stage").Set(float64(workers.size()))
+
+		select {
+		case <-time.After(stage.Duration):
+		case <-budget.exhausted():
+		}
+		sum := m.stats.reset()
+		logStage(dbType, stage.Clients, rate, sum)
+		sr := newStageResult(id, stage.Clients, sum)
+		sr.TargetRate = rate
+		stages = append(stages, sr)
+		if budgetSpent(budget) {
+			slog.Info("Operation count reached", "db", dbType, "operations", wl.operationCount)
+			return stages
+		}
+	}
+	return stages
+}
+
+// scheduleRate keeps one arrival schedule for the whole run and only
+// changes its rate, so the arrivals a stage left behind still run.
+func scheduleRate(arr *atomic.Pointer[arrivals], rate float64, poisson bool) {
+	if a := arr.Load(); a != nil {
+		a.setRate(rate)
+		return
+	}
+	arr.Store(newArrivals(rate, poisson))
+}
+
+func budgetSpent(budget *opBudget) bool {
+	select {
+	case <-budget.exhausted():
+		return true
+	default:
+		return false
+	}
+}
+
+func logStage(dbType string, clients int, rate float64, sum stageSummary) {
+	for _, op := range sum.opNames() {
+		c := sum.Ops[op]
+		slog.Info("Stage operation throughput", "db", dbType, "clients", clients, "op", op,
+			"ops", c.Ok, "errors", c.Failed, "ops_per_s", sum.throughput(op))
+	}
+	ok, failed := sum.totals()
+	slog.Info("Stage finished", "db", dbType, "clients", clients, "target_rate", rate, "duration", sum.Duration.Round(time.Millisecond),
+		"ops", ok, "errors", failed, "ops_per_s", sum.throughput(""))
+}
@@ -44,8 +44,7 @@ var loremIpsumWords = []string{
 	"a", "commodo", "fusce", "eu", "semper", "tellus", "sed", "efficitur", "pharetra", "ipsum",
 }
 
-func generateFTSContent() string {
-    const textLength = 10000
+func generateFTSContent(textLength int) string {
     const keywordCount = 3
 
     loremLength := len(loremIpsumWords)
@@ -66,4 +65,12 @@ func generateFTSContent() string {
     }
 
     return strings.Join(textContent, " ")
-}
\ No newline at end of file
+}
+
+// positiveOr returns v, or def when v is not positive.
+func positiveOr(v, def int) int {
+	if v > 0 {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ownedLimit caps how many created documents a worker remembers as targets
+// for read, update and delete. Older documents stay in the database.
+const ownedLimit = 1000
+
+var workloadOps = map[string]bool{
+	"create": true, "read": true, "update": true, "delete": true, "search": true, "search_fts": true,
+	"scan": true, "read_modify_write": true,
+}
+
+// workload is the validated form of WorkloadConfig shared by all workers.
+type workload struct {
+	name           string
+	thinkTime      time.Duration
+	ops            []OperationConfig
+	cumWeight      []int
+	recordCount    int
+	operationCount int
+	choose         chooser
+	open           bool
+	rate           float64
+	rateStep       float64
+	poisson        bool
+}
+
+func newWorkload(c WorkloadConfig) (*workload, error) {
+	c, err := applyPreset(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Operations) == 0 {
+		return nil, fmt.Errorf("workload %q has no operations", c.Name)
+	}
+	choose, err := newChooser(c.RequestDistribution)
+	if err != nil {
+		return nil, fmt.Errorf("workload %q: %w", c.Name, err)
+	}
+	w := &workload{
+		name:           c.Name,
+		thinkTime:      time.Duration(c.ThinkTimeMs) * time.Millisecond,
+		recordCount:    c.RecordCount,
+		operationCount: c.OperationCount,
+		choose:         choose,
+		rate:           c.Rate,
+		rateStep:       c.RateStep,
+	}
+	switch c.Mode {
+	case "", "closed":
+	case "open":
+		if c.Rate <= 0 {
+			return nil, fmt.Errorf("workload %q: open mode needs a positive rate", c.Name)
+		}
+		w.open = true
+	default:
+		return nil, fmt.Errorf("workload %q: unknown mode %q", c.Name, c.Mode)
+	}
+	switch c.Arrival {
+	case "", "fixed":
+	case "poisson":
+		w.poisson = true
+	default:
+		return nil, fmt.Errorf("workload %q: unknown arrival process %q", c.Name, c.Arrival)
+	}
+	total := 0
+	for _, op := range c.Operations {
+		if !workloadOps[op.Op] {
+			return nil, fmt.Errorf("workload %q: unknown operation %q", c.Name, op.Op)
+		}
+		if op.Op == "delete" && c.RecordCount > 0 {
+			return nil, fmt.Errorf("workload %q: delete cannot be combined with recordCount", c.Name)
+		}
+		if op.Weight <= 0 {
+			return nil, fmt.Errorf("workload %q: operation %q needs a positive weight", c.Name, op.Op)
+		}
+		if op.Words <= 0 {
+			op.Words = defaultTextWords
+		}
+		if op.MaxPrice <= 0 {
+			op.MaxPrice = 30
+		}
+		if op.Limit <= 0 {
+			op.Limit = 200
+		}
+		if op.Keyword == "" {
+			op.Keyword = ftsKeyword
+		}
+		total += op.Weight
+		w.ops = append(w.ops, op)
+		w.cumWeight = append(w.cumWeight, total)
+	}
+	return w, nil
+}
+
+// stageRate is the open-loop target rate of the stage with zero-based index
+// stage.
+func (w *workload) stageRate(stage int) float64 {
+	return w.rate + float64(stage)*w.rateStep
+}
+
+// pointOps are the operations that target a stored document and need
+// known ids to do so.
+var pointOps = map[string]bool{
+	"read": true, "update": true, "delete": true, "scan": true, "read_modify_write": true,
+}
+
+// targetsRecords reports whether the mix has an operation that targets a
+// stored document.
+func (w *workload) targetsRecords() bool {
+	for _, op := range w.ops {
+		if pointOps[op.Op] {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRates rejects a rateStep that would bring one of stages open-loop
+// stages to a rate of zero or below.
+func (w *workload) checkRates(stages int) error {
+	if !w.open {
+		return nil
+	}
+	for i := 0; i < stages; i++ {
+		if r := w.stageRate(i); r <= 0 {
+			return fmt.Errorf("workload %q: rateStep %g gives stage %d a rate of %g", w.name, w.rateStep, i+1, r)
+		}
+	}
+	return nil
+}
+
+func (w *workload) pick(rnd *rand.Rand) *OperationConfig {
+	n := rnd.Intn(w.cumWeight[len(w.cumWeight)-1])
+	for i, c := range w.cumWeight {
+		if n < c {
+			return &w.ops[i]
+		}
+	}
+	return &w.ops[len(w.ops)-1]
+}
+
+// worker is one closed-loop client. Without a shared keyspace it remembers
+// the documents it created so that read, update and delete target ids that
+// exist in the backend.
+type worker struct {
+	b      Backend
+	m      *metrics
+	w      *workload
+	ks     *keyspace
+	budget *opBudget
+	arr    *atomic.Pointer[arrivals]
+	rnd    *rand.Rand
+	owned  []project
+}
+
+// newWorker creates a client. arr holds the open-loop schedule shared by
+// all clients; it is created once and only its rate changes between
+// stages. It is unused for closed-loop workloads.
+func newWorker(b Backend, m *metrics, w *workload, ks *keyspace, budget *opBudget, arr *atomic.Pointer[arrivals]) *worker {
+	return &worker{b: b, m: m, w: w, ks: ks, budget: budget, arr: arr, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (wk *worker) loop(ctx context.Context) {
+	if wk.w.open {
+		wk.runOpen(ctx)
+	} else {
+		wk.run(ctx)
+	}
+}
+
+// run executes operations until ctx is cancelled or the budget is spent,
+// pausing for the think time after each one.
+func (wk *worker) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		if !wk.takeBudget() {
+			return
+		}
+		wk.step(wk.w.pick(wk.rnd), time.Now())
+		if wk.w.thinkTime > 0 {
+			select {
+			case <-time.After(wk.w.thinkTime):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// runOpen executes the arrivals of an open-loop schedule. When the backend
+// falls behind, the worker starts late but latency is still measured from
+// the scheduled time, so the delay is not omitted from the results. A
+// worker stopped by a shrinking pool hands its arrival back to the
+// schedule for the remaining workers.
+func (wk *worker) runOpen(ctx context.Context) {
+	for {
+		arr := wk.arr.Load()
+		due := arr.take()
+		if wait := time.Until(due); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				arr.giveBack(due)
+				return
+			}
+		} else {
+			select {
+			case <-ctx.Done():
+				arr.giveBack(due)
+				return
+			default:
+			}
+		}
+		if !wk.takeBudget() {
+			return
+		}
+		wk.step(wk.w.pick(wk.rnd), due)
+	}
+}
+
+// takeBudget charges the operation count, which only covers the measure
+// phase.
+func (wk *worker) takeBudget() bool {
+	return wk.m.phase() != phaseMeasure || wk.budget.take()
+}
+
+func (wk *worker) step(op *OperationConfig, start time.Time) error {
+	switch op.Op {
+	case "search":
+		return search(wk.b, wk.m, searchQuery{MaxPrice: op.MaxPrice, Limit: op.Limit}, start)
+	case "search_fts":
+		return searchFTS(wk.b, wk.m, searchQuery{Keyword: op.Keyword}, start)
+	case "create":
+		return wk.create(op.Words, start)
+	}
+
+	p, i, ok := wk.target()
+	if !ok {
+		// Operations on a document that was never created would only
+		// add not_found noise to the error counters, so create one first.
+		return wk.create(defaultTextWords, start)
+	}
+	switch op.Op {
+	case "read":
+		return p.read(wk.b, wk.m, start)
+	case "update":
+		p.Price = float32(random(1, 100))
+		return p.update(wk.b, wk.m, start)
+	case "scan":
+		return scan(wk.b, wk.m, searchQuery{MinPrice: float64(p.Price), Limit: 1 + wk.rnd.Intn(op.Limit)}, start)
+	case "read_modify_write":
+		err := wk.b.Read(p)
+		observeNested(wk.m, "read", start, err)
+		if err == nil {
+			p.Price = float32(random(1, 100))
+			updateStart := time.Now()
+			err = wk.b.Update(p)
+			observeNested(wk.m, "update", updateStart, err)
+		}
+		observeLatency(wk.m, "read_modify_write", start, err)
+		return err
+	case "delete":
+		err := p.delete(wk.b, wk.m, start)
+		wk.owned[i] = wk.owned[len(wk.owned)-1]
+		wk.owned = wk.owned[:len(wk.owned)-1]
+		return err
+	}
+	return fmt.Errorf("unknown operation %q", op.Op)
+}
+
+// target picks the document for a read, update, scan or delete. With a
+// shared keyspace the request distribution chooses it; otherwise it is a
+// random entry of owned, returned with its index.
+func (wk *worker) target() (*project, int, bool) {
+	if wk.ks != nil {
+		n := wk.ks.size()
+		if n == 0 {
+			return nil, -1, false
+		}
+		p := wk.ks.get(wk.w.choose(wk.rnd, n))
+		return &p, -1, true
+	}
+	if len(wk.owned) == 0 {
+		return nil, -1, false
+	}
+	i := wk.rnd.Intn(len(wk.owned))
+	p := wk.owned[i]
+	return &p, i, true
+}
+
+func (wk *worker) create(words int, start time.Time) error {
+	p := newProject(words)
+	if err := p.create(wk.b, wk.m, start); err != nil {
+		return err
+	}
+	// Only ids and price are needed later; keeping the text would hold
+	// every generated document in memory.
+	p.TextContent = ""
+	if wk.ks != nil {
+		wk.ks.add(p)
+		return nil
+	}
+	if len(wk.owned) >= ownedLimit {
+		copy(wk.owned, wk.owned[1:])
+		wk.owned = wk.owned[:len(wk.owned)-1]
+	}
+	wk.owned = append(wk.owned, p)
+	return nil
+}
+
+// arrivals hands out the scheduled start times of an open-loop stage, either
+// evenly spaced or as a Poisson process with the same mean rate.
+type arrivals struct {
+	mu       sync.Mutex
+	next     time.Time
+	interval time.Duration
+	poisson  bool
+	rnd      *rand.Rand
+	// returned holds arrivals handed back by stopped workers; they are
+	// all earlier than next.
+	returned []time.Time
+}
+
+func newArrivals(rate float64, poisson bool) *arrivals {
+	return &arrivals{
+		next:     time.Now(),
+		interval: time.Duration(float64(time.Second) / rate),
+		poisson:  poisson,
+		rnd:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// setRate changes the rate of the arrivals that follow the next one, so
+// arrivals still due at a stage boundary are kept rather than dropped.
+func (a *arrivals) setRate(rate float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.interval = time.Duration(float64(time.Second) / rate)
+}
+
+// restart drops the backlog and schedules the next arrival now, for a
+// schedule that resumes after a pause without clients.
+func (a *arrivals) restart() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.next = time.Now()
+	a.returned = nil
+}
+
+// giveBack returns an arrival that was taken but not executed, so another
+// worker runs it.
+func (a *arrivals) giveBack(due time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.returned = append(a.returned, due)
+}
+
+func (a *arrivals) take() time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if n := len(a.returned); n > 0 {
+		first := 0
+		for i, t := range a.returned {
+			if t.Before(a.returned[first]) {
+				first = i
+			}
+		}
+		due := a.returned[first]
+		a.returned[first] = a.returned[n-1]
+		a.returned = a.returned[:n-1]
+		return due
+	}
+	due := a.next
+	gap := a.interval
+	if a.poisson {
+		gap = time.Duration(a.rnd.ExpFloat64() * float64(a.interval))
+	}
+	a.next = a.next.Add(gap)
+	return due
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckRates(t *testing.T) {
+	tests := []struct {
+		name     string
+		open     bool
+		rate     float64
+		rateStep float64
+		stages   int
+		ok       bool
+	}{
+		{"closed loop ignores rates", false, 0, -10, 5, true},
+		{"constant rate", true, 100, 0, 5, true},
+		{"rising rate", true, 100, 50, 5, true},
+		{"falling rate stays positive", true, 100, -20, 5, true},
+		{"falling rate reaches zero", true, 100, -25, 5, false},
+		{"falling rate goes negative", true, 100, -60, 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &workload{name: "test", open: tt.open, rate: tt.rate, rateStep: tt.rateStep}
+			if err := w.checkRates(tt.stages); (err == nil) != tt.ok {
+				t.Errorf("checkRates(%d) = %v, want ok %v", tt.stages, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestArrivalsSetRateKeepsBacklog(t *testing.T) {
+	a := newArrivals(10, false)
+	start := a.take()
+	a.take()
+	a.setRate(100)
+	if got, want := a.take(), start.Add(200*time.Millisecond); !got.Equal(want) {
+		t.Errorf("first arrival after setRate = %v, want %v", got.Sub(start), want.Sub(start))
+	}
+	if got, want := a.take(), start.Add(210*time.Millisecond); !got.Equal(want) {
+		t.Errorf("second arrival after setRate = %v, want %v", got.Sub(start), want.Sub(start))
+	}
+}
+
+func TestTargetsRecords(t *testing.T) {
+	tests := []struct {
+		ops  []string
+		want bool
+	}{
+		{[]string{"search", "search_fts"}, false},
+		{[]string{"create", "search"}, false},
+		{[]string{"search", "read"}, true},
+		{[]string{"scan"}, true},
+		{[]string{"read_modify_write"}, true},
+	}
+	for _, tt := range tests {
+		w := &workload{}
+		for _, op := range tt.ops {
+			w.ops = append(w.ops, OperationConfig{Op: op})
+		}
+		if got := w.targetsRecords(); got != tt.want {
+			t.Errorf("targetsRecords(%v) = %v, want %v", tt.ops, got, tt.want)
+		}
+	}
+}
+
+func TestArrivalsGiveBack(t *testing.T) {
+	a := newArrivals(10, false)
+	first := a.take()
+	second := a.take()
+	third := a.take()
+	a.giveBack(third)
+	a.giveBack(first)
+	for i, want := range []time.Time{first, third, third.Add(100 * time.Millisecond)} {
+		if got := a.take(); !got.Equal(want) {
+			t.Errorf("take %d = %v, want %v", i+1, got.Sub(second), want.Sub(second))
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ycsbPresets are the YCSB core workloads expressed as operation mixes.
+// Record count, operation count and distribution defaults match the YCSB
+// workload files; anything set explicitly in the config wins.
+var ycsbPresets = map[string]WorkloadConfig{
+	"a": {Name: "ycsb-a", RequestDistribution: "zipfian", Operations: []OperationConfig{
+		{Op: "read", Weight: 50}, {Op: "update", Weight: 50},
+	}},
+	"b": {Name: "ycsb-b", RequestDistribution: "zipfian", Operations: []OperationConfig{
+		{Op: "read", Weight: 95}, {Op: "update", Weight: 5},
+	}},
+	"c": {Name: "ycsb-c", RequestDistribution: "zipfian", Operations: []OperationConfig{
+		{Op: "read", Weight: 100},
+	}},
+	"d": {Name: "ycsb-d", RequestDistribution: "latest", Operations: []OperationConfig{
+		{Op: "read", Weight: 95}, {Op: "create", Weight: 5, Words: ycsbTextWords},
+	}},
+	"e": {Name: "ycsb-e", RequestDistribution: "zipfian", Operations: []OperationConfig{
+		{Op: "scan", Weight: 95, Limit: 100}, {Op: "create", Weight: 5, Words: ycsbTextWords},
+	}},
+	"f": {Name: "ycsb-f", RequestDistribution: "zipfian", Operations: []OperationConfig{
+		{Op: "read", Weight: 50}, {Op: "read_modify_write", Weight: 50},
+	}},
+}
+
+const (
+	ycsbRecordCount    = 1000
+	ycsbOperationCount = 1000
+	// ycsbTextWords makes a project about 1 KB, the size of a YCSB record
+	// of ten 100-byte fields.
+	ycsbTextWords = 150
+)
+
+// applyPreset fills c from the YCSB preset it names. Fields already set in c
+// are kept.
+func applyPreset(c WorkloadConfig) (WorkloadConfig, error) {
+	if c.Preset == "" {
+		return c, nil
+	}
+	p, ok := ycsbPresets[strings.ToLower(c.Preset)]
+	if !ok {
+		return c, fmt.Errorf("unknown workload preset %q", c.Preset)
+	}
+	if c.Name == "" {
+		c.Name = p.Name
+	}
+	if len(c.Operations) == 0 {
+		c.Operations = p.Operations
+	}
+	if c.RequestDistribution == "" {
+		c.RequestDistribution = p.RequestDistribution
+	}
+	if c.RecordCount == 0 {
+		c.RecordCount = ycsbRecordCount
+	}
+	if c.OperationCount == 0 {
+		c.OperationCount = ycsbOperationCount
+	}
+	return c, nil
+}
+
+// chooser picks a record index in [0, n) from the YCSB request
+// distribution.
+type chooser func(rnd *rand.Rand, n int) int
+
+// zipfS is the Zipf exponent. YCSB uses 0.99, but math/rand requires s > 1.
+const zipfS = 1.01
+
+func newChooser(dist string) (chooser, error) {
+	switch dist {
+	case "", "uniform":
+		return func(rnd *rand.Rand, n int) int { return rnd.Intn(n) }, nil
+	case "zipfian":
+		return func(rnd *rand.Rand, n int) int {
+			if n == 1 {
+				return 0
+			}
+			return int(rand.NewZipf(rnd, zipfS, 1, uint64(n-1)).Uint64())
+		}, nil
+	case "latest":
+		// Like zipfian, but the most recently inserted records are hot.
+		return func(rnd *rand.Rand, n int) int {
+			if n == 1 {
+				return 0
+			}
+			return n - 1 - int(rand.NewZipf(rnd, zipfS, 1, uint64(n-1)).Uint64())
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown request distribution %q", dist)
+}
+
+// keyspace is the set of records shared by all workers when the workload
+// has a record count. Inserts append, so index order is insertion order.
+type keyspace struct {
+	mu      sync.RWMutex
+	records []project
+}
+
+// add stores the ids and price of p; the text is dropped to keep large
+// record counts in memory.
+func (k *keyspace) add(p project) {
+	p.TextContent = ""
+	k.mu.Lock()
+	k.records = append(k.records, p)
+	k.mu.Unlock()
+}
+
+func (k *keyspace) size() int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.records)
+}
+
+// get returns a copy of record i, so workers never share a project.
+func (k *keyspace) get(i int) project {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.records[i]
+}
+
+// opBudget stops the run after a fixed number of operations, like YCSB's
+// operationcount. A nil budget is unlimited.
+type opBudget struct {
+	left atomic.Int64
+	once sync.Once
+	done chan struct{}
+}
+
+func newOpBudget(n int) *opBudget {
+	if n <= 0 {
+		return nil
+	}
+	b := &opBudget{done: make(chan struct{})}
+	b.left.Store(int64(n))
+	return b
+}
+
+// take reserves one operation and reports whether it may run.
+func (b *opBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	if b.left.Add(-1) >= 0 {
+		return true
+	}
+	b.once.Do(func() { close(b.done) })
+	return false
+}
+
+// exhausted is closed once the budget is spent; it blocks forever for a nil
+// budget.
+func (b *opBudget) exhausted() <-chan struct{} {
+	if b == nil {
+		return nil
+	}
+	return b.done
+}
+
+var ycsbOpNames = map[string]string{
+	"create":            "INSERT",
+	"read":              "READ",
+	"update":            "UPDATE",
+	"delete":            "DELETE",
+	"scan":              "SCAN",
+	"read_modify_write": "READ-MODIFY-WRITE",
+}
+
+// writeYCSB renders r in the layout of the YCSB measurement summary, one
+// block per backend and stage, so numbers can be checked against YCSB runs.
+func writeYCSB(w io.Writer, r *runResult) error {
+	for _, b := range r.Backends {
+		for _, s := range b.Stages {
+			// Steps of a read_modify_write are listed under READ and
+			// UPDATE, as in YCSB, but only the operation itself counts.
+			var ops int
+			for _, o := range s.Ops {
+				ops += o.Count - o.Nested
+			}
+			fmt.Fprintf(w, "# db=%s stage=%d clients=%d workload=%s\n", b.Name, s.Stage, s.Clients, r.Workload)
+			fmt.Fprintf(w, "[OVERALL], RunTime(ms), %.0f\n", s.DurationS*1000)
+			throughput := 0.0
+			if s.DurationS > 0 {
+				throughput = float64(ops) / s.DurationS
+			}
+			fmt.Fprintf(w, "[OVERALL], Throughput(ops/sec), %s\n", formatFloat(throughput))
+			for _, o := range s.Ops {
+				name, ok := ycsbOpNames[o.Op]
+				if !ok {
+					name = strings.ToUpper(o.Op)
+				}
+				fmt.Fprintf(w, "[%s], Operations, %d\n", name, o.Count)
+				fmt.Fprintf(w, "[%s], AverageLatency(us), %s\n", name, formatFloat(o.MeanMs*1000))
+				fmt.Fprintf(w, "[%s], MinLatency(us), %.0f\n", name, o.MinMs*1000)
+				fmt.Fprintf(w, "[%s], MaxLatency(us), %.0f\n", name, o.MaxMs*1000)
+				fmt.Fprintf(w, "[%s], 95thPercentileLatency(us), %.0f\n", name, o.P95Ms*1000)
+				fmt.Fprintf(w, "[%s], 99thPercentileLatency(us), %.0f\n", name, o.P99Ms*1000)
+				fmt.Fprintf(w, "[%s], Return=OK, %d\n", name, o.Count)
+				if o.Errors > 0 {
+					fmt.Fprintf(w, "[%s], Return=ERROR, %d\n", name, o.Errors)
+				}
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	return nil
+}
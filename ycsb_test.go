@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChooserRange(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, dist := range []string{"", "uniform", "zipfian", "latest"} {
+		choose, err := newChooser(dist)
+		if err != nil {
+			t.Fatalf("newChooser(%q) error: %v", dist, err)
+		}
+		for _, n := range []int{1, 2, 10, 1000} {
+			for i := 0; i < 1000; i++ {
+				if k := choose(rnd, n); k < 0 || k >= n {
+					t.Fatalf("%q chooser picked %d of %d records", dist, k, n)
+				}
+			}
+		}
+	}
+	if _, err := newChooser("hotspot"); err == nil {
+		t.Error("newChooser(hotspot) succeeded, want an error")
+	}
+}
+
+func TestChooserSkew(t *testing.T) {
+	tests := []struct {
+		dist string
+		hot  int
+	}{
+		{"zipfian", 0},
+		{"latest", 999},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dist, func(t *testing.T) {
+			choose, _ := newChooser(tt.dist)
+			rnd := rand.New(rand.NewSource(1))
+			hits := 0
+			for i := 0; i < 10000; i++ {
+				if choose(rnd, 1000) == tt.hot {
+					hits++
+				}
+			}
+			// Uniform picks would hit one record about 10 times.
+			if hits < 1000 {
+				t.Errorf("record %d picked %d times in 10000, want a hot record", tt.hot, hits)
+			}
+		})
+	}
+}
+
+func TestApplyPreset(t *testing.T) {
+	ops := []OperationConfig{{Op: "search", Weight: 1}}
+	tests := []struct {
+		name string
+		in   WorkloadConfig
+		want WorkloadConfig
+	}{
+		{
+			name: "no preset",
+			in:   WorkloadConfig{Name: "mixed", Operations: ops},
+			want: WorkloadConfig{Name: "mixed", Operations: ops},
+		},
+		{
+			name: "defaults from the preset",
+			in:   WorkloadConfig{Preset: "A"},
+			want: WorkloadConfig{
+				Name: "ycsb-a", Preset: "A", RequestDistribution: "zipfian",
+				Operations:  ycsbPresets["a"].Operations,
+				RecordCount: ycsbRecordCount, OperationCount: ycsbOperationCount,
+			},
+		},
+		{
+			name: "explicit fields win",
+			in: WorkloadConfig{
+				Name: "mine", Preset: "d", RequestDistribution: "uniform", Operations: ops,
+				RecordCount: 5, OperationCount: 7,
+			},
+			want: WorkloadConfig{
+				Name: "mine", Preset: "d", RequestDistribution: "uniform", Operations: ops,
+				RecordCount: 5, OperationCount: 7,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyPreset(tt.in)
+			if err != nil {
+				t.Fatalf("applyPreset() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyPreset() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if _, err := applyPreset(WorkloadConfig{Preset: "g"}); err == nil {
+		t.Error("applyPreset(g) succeeded, want an error")
+	}
+}
+
+func TestWriteYCSBCountsTopLevelOps(t *testing.T) {
+	// Workload F: 50 reads and 50 read_modify_writes, each of which also
+	// records its own read and update.
+	s := newStageStats()
+	for i := 0; i < 50; i++ {
+		s.record("read", time.Millisecond, nil, false)
+		s.record("read", time.Millisecond, nil, true)
+		s.record("update", time.Millisecond, nil, true)
+		s.record("read_modify_write", 2*time.Millisecond, nil, false)
+	}
+	sum := s.reset()
+	sum.Duration = 10 * time.Second
+	if ok, failed := sum.totals(); ok != 100 || failed != 0 {
+		t.Errorf("totals() = %d, %d, want 100, 0", ok, failed)
+	}
+
+	var buf bytes.Buffer
+	r := &runResult{Workload: "ycsb-f", Backends: []backendResult{{Name: "pg", Stages: []stageResult{newStageResult(1, 4, sum)}}}}
+	if err := writeYCSB(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"[OVERALL], Throughput(ops/sec), 10.000\n",
+		"[READ], Operations, 100\n",
+		"[UPDATE], Operations, 50\n",
+		"[READ-MODIFY-WRITE], Operations, 50\n",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("output lacks %q:\n%s", line, buf.String())
+		}
+	}
+}